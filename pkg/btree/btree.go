@@ -56,6 +56,7 @@
 package btree
 
 import (
+	"math"
 	"sort"
 
 	"github.com/tikv/pd/pkg/syncutil"
@@ -96,6 +97,23 @@ func NewFreeList(size int) *FreeList {
 	return &FreeList{freelist: make([]*node, 0, size)}
 }
 
+// NewPreallocatedFreeList creates a new free list of the given size which is
+// filled with size nodes for the B-Trees of the given degree. The nodes and
+// their item slices with the capacity of a full node are carved from a few
+// large allocations, so the trees using the free list do not allocate them
+// one by one until it is drained.
+func NewPreallocatedFreeList(size, degree int) *FreeList {
+	f := NewFreeList(size)
+	maxItems := degree*2 - 1
+	nodes := make([]node, size)
+	backing := make(items, size*maxItems)
+	for i := range nodes {
+		nodes[i].items = backing[i*maxItems : i*maxItems : (i+1)*maxItems]
+		f.freelist = append(f.freelist, &nodes[i])
+	}
+	return f
+}
+
 func (f *FreeList) newNode() (n *node) {
 	f.mu.Lock()
 	index := len(f.freelist) - 1
@@ -1066,6 +1084,109 @@ func (t *BTree) Clear(addNodesToFreelist bool) {
 	t.root, t.length = nil, 0
 }
 
+// Rebuild rebuilds the tree from its items in place with the fewest levels and
+// the nodes filled as much as possible, which improves the cache locality after
+// many deletions left the nodes sparsely filled.  The old nodes owned by t are
+// added to its freelist like Clear(true).
+//
+// This call takes O(tree size).
+func (t *BTree) Rebuild() {
+	items := make([]Item, 0, t.length)
+	t.Ascend(func(item Item) bool {
+		items = append(items, item)
+		return true
+	})
+	t.Clear(true)
+	if len(items) == 0 {
+		return
+	}
+	height := 1
+	for t.capacity(height) < len(items) {
+		height++
+	}
+	t.root, t.length = t.build(items, height), len(items)
+}
+
+// capacity returns the max number of items of a subtree with the given height,
+// which is capped at the max int.
+func (t *BTree) capacity(height int) int {
+	c := 1
+	for i := 0; i < height; i++ {
+		if c > math.MaxInt/(t.maxItems()+1) {
+			return math.MaxInt
+		}
+		c *= t.maxItems() + 1
+	}
+	return c - 1
+}
+
+// build builds a subtree with the given height from the sorted items, the items
+// are evenly distributed to the fewest children.
+func (t *BTree) build(items []Item, height int) *node {
+	n := t.cow.newNode()
+	if height == 1 {
+		n.items = append(n.items, items...)
+		return n
+	}
+	sub := t.capacity(height - 1)
+	// k children of at most sub items and k-1 separators hold k*(sub+1)-1 items.
+	k := (len(items) + sub + 1) / (sub + 1)
+	if k < 2 {
+		k = 2
+	}
+	base, rem := (len(items)-k+1)/k, (len(items)-k+1)%k
+	pos := 0
+	for i := 0; i < k; i++ {
+		size := base
+		if i < rem {
+			size++
+		}
+		n.children = append(n.children, t.build(items[pos:pos+size], height-1))
+		pos += size
+		if i < k-1 {
+			n.items = append(n.items, items[pos])
+			pos++
+		}
+	}
+	n.initSize()
+	return n
+}
+
+// SharesRoot returns true if t and other share the same root node, which means
+// they have the same items since a write never modifies a node shared by the
+// clones, e.g. t and other are clones not written since Clone.
+func (t *BTree) SharesRoot(other *BTree) bool {
+	return t.root != nil && t.root == other.root
+}
+
+// NodeCount returns the number of nodes currently in the tree.
+func (t *BTree) NodeCount() int {
+	if t.root == nil {
+		return 0
+	}
+	return t.root.nodeCount()
+}
+
+func (n *node) nodeCount() int {
+	count := 1
+	for _, child := range n.children {
+		count += child.nodeCount()
+	}
+	return count
+}
+
+// Height returns the number of levels of the tree, which is 0 for an empty tree.
+func (t *BTree) Height() int {
+	if t.length == 0 {
+		return 0
+	}
+	height := 1
+	for n := t.root; len(n.children) > 0; n = n.children[0] {
+		height++
+	}
+	return height
+}
+
 // reset returns a subtree to the freelist.  It breaks out immediately if the
 // freelist is full, since the only benefit of iterating is to fill that
 // freelist up.  Returns true if parent reset call should continue.
@@ -863,3 +863,137 @@ func BenchmarkDeleteAndRestore(b *testing.B) {
 		}
 	})
 }
+
+func TestRebuild(t *testing.T) {
+	tr := New(*btreeDegree)
+	tr.Rebuild()
+	assertEq(t, "empty height", tr.Height(), 0)
+	assertEq(t, "empty node count", tr.NodeCount(), 0)
+	for _, degree := range []int{2, 3, 32} {
+		base := New(degree)
+		for size := 1; size <= 3000; size++ {
+			base.ReplaceOrInsert(Int(size))
+			tr := base.Clone()
+			tr.Rebuild()
+			checkNodes(t, tr, fmt.Sprintf("degree %d size %d", degree, size))
+			assertEq(t, "len", tr.Len(), size)
+		}
+	}
+	for _, size := range []int{1, 63, 64, 100, 4096, 4097, 10000} {
+		tr := New(*btreeDegree)
+		for _, item := range perm(size * 3) {
+			tr.ReplaceOrInsert(item)
+		}
+		// delete x if x % 3 != 0
+		for _, item := range perm(size * 3) {
+			if int(item.(Int))%3 != 0 {
+				tr.Delete(item)
+			}
+		}
+		nodes := tr.NodeCount()
+		tr.Rebuild()
+		checkNodes(t, tr, fmt.Sprintf("size %d", size))
+		if tr.NodeCount() > nodes {
+			t.Fatalf("size %d: node count increased from %d to %d", size, nodes, tr.NodeCount())
+		}
+		// a full node holds maxItems items, so the nodes are at least half filled.
+		if tr.NodeCount()*tr.maxItems() > 2*size+tr.maxItems() {
+			t.Fatalf("size %d: too many nodes %d after rebuild", size, tr.NodeCount())
+		}
+		if got, want := tr.capacity(tr.Height()-1), size; got >= want {
+			t.Fatalf("size %d: height %d is not the fewest", size, tr.Height())
+		}
+		assertEq(t, "root length", tr.getRootLength(), size)
+		assertEq(t, "len", tr.Len(), size)
+		for k := 0; k < size; k++ {
+			assertEq(t, "get k-th", tr.GetAt(k), Int(3*k))
+		}
+		// the rebuilt tree is still mutable.
+		for _, item := range perm(size * 3) {
+			tr.ReplaceOrInsert(item)
+		}
+		if got := all(tr); !reflect.DeepEqual(got, rang(size*3)) {
+			t.Fatalf("size %d: mismatch after reinsert", size)
+		}
+		for _, item := range perm(size * 3) {
+			tr.Delete(item)
+		}
+		assertEq(t, "len after delete", tr.Len(), 0)
+	}
+}
+
+// checkNodes checks every node holds at most maxItems items and every node
+// except the root holds at least minItems items, and all leaves are at the same
+// depth.
+func checkNodes(t *testing.T, tr *BTree, name string) {
+	leafDepth := -1
+	var walk func(n *node, depth int)
+	walk = func(n *node, depth int) {
+		if len(n.items) > tr.maxItems() {
+			t.Fatalf("%s: node at depth %d has %d items, max %d", name, depth, len(n.items), tr.maxItems())
+		}
+		if n != tr.root && len(n.items) < tr.minItems() {
+			t.Fatalf("%s: node at depth %d has %d items, min %d", name, depth, len(n.items), tr.minItems())
+		}
+		if len(n.children) == 0 {
+			if leafDepth >= 0 && depth != leafDepth {
+				t.Fatalf("%s: leaves at depth %d and %d", name, leafDepth, depth)
+			}
+			leafDepth = depth
+			return
+		}
+		if len(n.children) != len(n.items)+1 {
+			t.Fatalf("%s: node has %d items and %d children", name, len(n.items), len(n.children))
+		}
+		for _, child := range n.children {
+			walk(child, depth+1)
+		}
+	}
+	walk(tr.root, 0)
+}
+
+func TestPreallocatedFreeList(t *testing.T) {
+	const size = 10000
+	items := perm(size)
+	load := func(newFreeList func() *FreeList) float64 {
+		return testing.AllocsPerRun(5, func() {
+			tr := NewWithFreeList(*btreeDegree, newFreeList())
+			for _, item := range items {
+				tr.ReplaceOrInsert(item)
+			}
+		})
+	}
+	// the nodes are at least half filled, so size/(degree-1)+1 nodes are enough.
+	nodes := size/(*btreeDegree-1) + 1
+	preallocated := load(func() *FreeList { return NewPreallocatedFreeList(nodes, *btreeDegree) })
+	// only the children of the few internal nodes are allocated.
+	if preallocated > 64 {
+		t.Fatalf("%v allocations with the preallocated nodes", preallocated)
+	}
+	if allocated := load(func() *FreeList { return NewFreeList(nodes) }); allocated < float64(size / *btreeDegree) {
+		t.Fatalf("%v allocations without the preallocated nodes", allocated)
+	}
+
+	tr := NewWithFreeList(*btreeDegree, NewPreallocatedFreeList(nodes, *btreeDegree))
+	for _, item := range items {
+		tr.ReplaceOrInsert(item)
+	}
+	checkNodes(t, tr, "preallocated")
+	if got := all(tr); !reflect.DeepEqual(got, rang(size)) {
+		t.Fatalf("mismatch with the preallocated nodes")
+	}
+}
+
+func TestSharesRoot(t *testing.T) {
+	tr := New(*btreeDegree)
+	assertEq(t, "empty", tr.SharesRoot(tr.Clone()), false)
+	for _, item := range perm(1000) {
+		tr.ReplaceOrInsert(item)
+	}
+	tr2 := tr.Clone()
+	assertEq(t, "clone", tr.SharesRoot(tr2), true)
+	assertEq(t, "clone reverse", tr2.SharesRoot(tr), true)
+	tr2.ReplaceOrInsert(Int(0))
+	assertEq(t, "written clone", tr.SharesRoot(tr2), false)
+	assertEq(t, "other tree", tr.SharesRoot(New(*btreeDegree)), false)
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+import (
+	"container/list"
+)
+
+type overlapsCacheKey struct {
+	startKey string
+	endKey   string
+}
+
+type overlapsCacheEntry struct {
+	key      overlapsCacheKey
+	overlaps []RangeItem
+}
+
+// CachedRangeTree is a RangeTree with a LRU cache of the recent GetOverlaps results.
+// The cache is dropped whenever the version of the underlying tree changes, so it
+// mainly benefits the read-heavy phases with rare mutations.
+// It is not thread-safe.
+type CachedRangeTree struct {
+	*RangeTree
+	// maxCount is the maximum number of cached queries.
+	// 0 means no limit.
+	maxCount int
+	version  uint64
+	ll       *list.List
+	cache    map[overlapsCacheKey]*list.Element
+	hits     uint64
+	misses   uint64
+}
+
+// NewCachedRangeTree wraps the given range tree with a GetOverlaps cache of the given size.
+func NewCachedRangeTree(tree *RangeTree, size int) *CachedRangeTree {
+	return &CachedRangeTree{
+		RangeTree: tree,
+		maxCount:  size,
+		version:   tree.Version(),
+		ll:        list.New(),
+		cache:     make(map[overlapsCacheKey]*list.Element),
+	}
+}
+
+// GetOverlaps returns the range items that has some intersections with the given items.
+// The cached slice is shared by the queries with the same range, it must not be modified or passed
+// to PutOverlaps.
+func (c *CachedRangeTree) GetOverlaps(item RangeItem) []RangeItem {
+	if v := c.RangeTree.Version(); v != c.version {
+		c.ll.Init()
+		c.cache = make(map[overlapsCacheKey]*list.Element)
+		c.version = v
+	}
+	key := overlapsCacheKey{startKey: string(item.GetStartKey()), endKey: string(item.GetEndKey())}
+	if ele, ok := c.cache[key]; ok {
+		c.hits++
+		c.ll.MoveToFront(ele)
+		return ele.Value.(*overlapsCacheEntry).overlaps
+	}
+	c.misses++
+	overlaps := c.RangeTree.GetOverlaps(item)
+	c.cache[key] = c.ll.PushFront(&overlapsCacheEntry{key: key, overlaps: overlaps})
+	if c.maxCount != 0 && c.ll.Len() > c.maxCount {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.cache, oldest.Value.(*overlapsCacheEntry).key)
+	}
+	return overlaps
+}
+
+// CacheStats returns the hit and miss count of the GetOverlaps cache.
+func (c *CachedRangeTree) CacheStats() (hits, misses uint64) {
+	return c.hits, c.misses
+}
@@ -0,0 +1,58 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedRangeTree(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	cachedTree := NewCachedRangeTree(NewRangeTree(2, bucketDebrisFactory), 2)
+	cachedTree.Update(newSimpleBucketItem([]byte("000"), []byte("100")))
+	cachedTree.Update(newSimpleBucketItem([]byte("100"), []byte("200")))
+
+	query := newSimpleBucketItem([]byte("050"), []byte("150"))
+	overlaps := cachedTree.GetOverlaps(query)
+	re.Len(overlaps, 2)
+	re.Equal(overlaps, cachedTree.GetOverlaps(newSimpleBucketItem([]byte("050"), []byte("150"))))
+	hits, misses := cachedTree.CacheStats()
+	re.Equal(uint64(1), hits)
+	re.Equal(uint64(1), misses)
+
+	// the oldest query is evicted when the cache is full.
+	cachedTree.GetOverlaps(newSimpleBucketItem([]byte("000"), []byte("010")))
+	cachedTree.GetOverlaps(newSimpleBucketItem([]byte("110"), []byte("120")))
+	cachedTree.GetOverlaps(query)
+	hits, misses = cachedTree.CacheStats()
+	re.Equal(uint64(1), hits)
+	re.Equal(uint64(4), misses)
+
+	// a mutation invalidates the cache.
+	cachedTree.GetOverlaps(query)
+	cachedTree.Update(newSimpleBucketItem([]byte("000"), []byte("200")))
+	overlaps = cachedTree.GetOverlaps(query)
+	re.Len(overlaps, 1)
+	hits, misses = cachedTree.CacheStats()
+	re.Equal(uint64(2), hits)
+	re.Equal(uint64(5), misses)
+
+	// the mutations applied to the underlying tree are also observed.
+	cachedTree.RangeTree.Remove(overlaps[0])
+	re.Empty(cachedTree.GetOverlaps(query))
+}
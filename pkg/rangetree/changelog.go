@@ -0,0 +1,91 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+// ChangeOp is the type of a change of the tree.
+type ChangeOp int
+
+const (
+	// ChangeInsert is the insertion of an item.
+	ChangeInsert ChangeOp = iota
+	// ChangeDelete is the deletion of an item.
+	ChangeDelete
+)
+
+// ChangeEvent is an insertion or deletion of an item recorded by the change log, Version is the
+// version of the tree after the mutation producing the change.
+type ChangeEvent struct {
+	Op      ChangeOp
+	Item    RangeItem
+	Version uint64
+}
+
+// changeLog keeps the latest changes of the tree, see EnableChangeLog.
+type changeLog struct {
+	maxEntries int
+	events     []ChangeEvent
+	// since is the earliest version from which all later changes are kept.
+	since uint64
+}
+
+// EnableChangeLog makes the tree record its latest insertions and deletions, at most maxEntries
+// changes are kept and the older ones are evicted. A non-positive maxEntries disables the change log.
+// Replacing an item with the same start key is recorded as a deletion followed by an insertion. The
+// changes made before enabling it, or by Swap, are not recorded, and the forks do not inherit it.
+func (r *RangeTree) EnableChangeLog(maxEntries int) {
+	if maxEntries <= 0 {
+		r.changeLog = nil
+		return
+	}
+	r.changeLog = &changeLog{maxEntries: maxEntries, since: r.version}
+}
+
+// Since returns the changes made after the given version in order, so a downstream cache at the
+// version can apply them to catch up with the tree. It returns false if the change log is disabled
+// or some of the changes have been evicted or not recorded.
+func (r *RangeTree) Since(version uint64) ([]ChangeEvent, bool) {
+	l := r.changeLog
+	if l == nil || version < l.since {
+		return nil, false
+	}
+	i := len(l.events)
+	for i > 0 && l.events[i-1].Version > version {
+		i--
+	}
+	return append([]ChangeEvent(nil), l.events[i:]...), true
+}
+
+// recordChange records a change of the ongoing mutation, which is done before increasing the version.
+func (r *RangeTree) recordChange(op ChangeOp, item RangeItem) {
+	l := r.changeLog
+	if l == nil {
+		return
+	}
+	if len(l.events) >= l.maxEntries {
+		if evicted := l.events[0].Version; evicted > l.since {
+			l.since = evicted
+		}
+		l.events = l.events[1:]
+	}
+	l.events = append(l.events, ChangeEvent{Op: op, Item: item, Version: r.version + 1})
+}
+
+// resetChangeLog drops all recorded changes when the contents are replaced without recording them.
+func (r *RangeTree) resetChangeLog() {
+	if r.changeLog != nil {
+		r.changeLog.events = nil
+		r.changeLog.since = r.version
+	}
+}
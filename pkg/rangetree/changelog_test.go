@@ -0,0 +1,125 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangeLog(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("050")))
+	_, ok := bucketTree.Since(0)
+	re.False(ok)
+
+	snapshot := func() map[string]string {
+		res := make(map[string]string)
+		bucketTree.ScanRange(newSimpleBucketItem([]byte(""), nil), func(item RangeItem) bool {
+			res[string(item.GetStartKey())] = string(item.GetEndKey())
+			return true
+		})
+		return res
+	}
+	replay := func(mirror map[string]string, events []ChangeEvent) {
+		for _, event := range events {
+			switch event.Op {
+			case ChangeInsert:
+				mirror[string(event.Item.GetStartKey())] = string(event.Item.GetEndKey())
+			case ChangeDelete:
+				re.Equal(string(event.Item.GetEndKey()), mirror[string(event.Item.GetStartKey())])
+				delete(mirror, string(event.Item.GetStartKey()))
+			}
+		}
+	}
+
+	bucketTree.EnableChangeLog(100)
+	start := bucketTree.Version()
+	events, ok := bucketTree.Since(start)
+	re.True(ok)
+	re.Empty(events)
+	// the changes before enabling the change log are unknown.
+	_, ok = bucketTree.Since(start - 1)
+	re.False(ok)
+
+	mirror := snapshot()
+	bucketTree.Update(newSimpleBucketItem([]byte("020"), []byte("030")))
+	events, ok = bucketTree.Since(start)
+	re.True(ok)
+	re.Equal([]ChangeEvent{
+		{Op: ChangeDelete, Item: newSimpleBucketItem([]byte("010"), []byte("050")), Version: start + 1},
+		{Op: ChangeInsert, Item: newSimpleBucketItem([]byte("010"), []byte("020")), Version: start + 1},
+		{Op: ChangeInsert, Item: newSimpleBucketItem([]byte("030"), []byte("050")), Version: start + 1},
+		{Op: ChangeInsert, Item: newSimpleBucketItem([]byte("020"), []byte("030")), Version: start + 1},
+	}, events)
+	checkpoint := bucketTree.Version()
+	replay(mirror, events)
+	re.Equal(snapshot(), mirror)
+
+	// replay the updates, removes and replacements from the checkpoint.
+	bucketTree.Update(newSimpleBucketItem([]byte("015"), []byte("035")))
+	bucketTree.Remove(newSimpleBucketItem([]byte("035"), nil))
+	bucketTree.Update(newSimpleBucketItem([]byte("060"), []byte("070")))
+	bucketTree.ExtendEnd(newSimpleBucketItem([]byte("060"), nil), []byte("080"), func(src RangeItem, newEnd []byte) RangeItem {
+		return newSimpleBucketItem(src.GetStartKey(), newEnd)
+	})
+	bucketTree.SetDeferredDelete(true)
+	bucketTree.Remove(newSimpleBucketItem([]byte("010"), nil))
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("012")))
+	events, ok = bucketTree.Since(checkpoint)
+	re.True(ok)
+	for _, event := range events {
+		re.Greater(event.Version, checkpoint)
+	}
+	replay(mirror, events)
+	re.Equal(snapshot(), mirror)
+	re.Equal(map[string]string{"010": "012", "015": "035", "060": "080"}, mirror)
+	events, ok = bucketTree.Since(bucketTree.Version())
+	re.True(ok)
+	re.Empty(events)
+
+	// the version whose changes are evicted is invalid.
+	bucketTree.EnableChangeLog(4)
+	checkpoint = bucketTree.Version()
+	bucketTree.Update(newSimpleBucketItem([]byte("100"), []byte("200")))
+	bucketTree.Update(newSimpleBucketItem([]byte("150"), []byte("160")))
+	_, ok = bucketTree.Since(checkpoint)
+	re.False(ok)
+	events, ok = bucketTree.Since(checkpoint + 1)
+	re.True(ok)
+	re.Len(events, 4)
+	// the partially evicted version is invalid too.
+	bucketTree.Update(newSimpleBucketItem([]byte("300"), []byte("400")))
+	_, ok = bucketTree.Since(checkpoint + 1)
+	re.False(ok)
+	events, ok = bucketTree.Since(checkpoint + 2)
+	re.True(ok)
+	re.Len(events, 1)
+
+	// the swapped contents are not recorded.
+	bucketTree.Swap(NewRangeTree(2, bucketDebrisFactory))
+	_, ok = bucketTree.Since(checkpoint + 1)
+	re.False(ok)
+	events, ok = bucketTree.Since(bucketTree.Version())
+	re.True(ok)
+	re.Empty(events)
+
+	bucketTree.EnableChangeLog(0)
+	_, ok = bucketTree.Since(bucketTree.Version())
+	re.False(ok)
+}
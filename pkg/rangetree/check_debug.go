@@ -0,0 +1,33 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build rangetree_debug
+// +build rangetree_debug
+
+package rangetree
+
+import "fmt"
+
+// checkOverlaps panics if the overlaps are not sorted or some of them overlap each other, which
+// means the tree is corrupted, e.g. by a buggy factory. It is only compiled with the rangetree_debug
+// build tag to surface the corruption at the point of use.
+func checkOverlaps(overlaps []RangeItem) {
+	for i := 1; i < len(overlaps); i++ {
+		prev, curr := overlaps[i-1], overlaps[i]
+		if !prev.Less(curr) || Overlap(prev, curr) {
+			panic(fmt.Sprintf("rangetree: corrupted overlaps, item %d [%q, %q) and item %d [%q, %q)",
+				i-1, prev.GetStartKey(), prev.GetEndKey(), i, curr.GetStartKey(), curr.GetEndKey()))
+		}
+	}
+}
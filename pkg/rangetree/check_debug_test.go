@@ -0,0 +1,47 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build rangetree_debug
+// +build rangetree_debug
+
+package rangetree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckOverlaps(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	tree := NewRangeTree(2, bucketDebrisFactory)
+	tree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	tree.Update(newSimpleBucketItem([]byte("020"), []byte("030")))
+	re.NotPanics(func() {
+		tree.GetOverlaps(newSimpleBucketItem([]byte(""), []byte("")))
+	})
+
+	// a deliberately corrupted tree.
+	tree.tree.ReplaceOrInsert(newSimpleBucketItem([]byte("015"), []byte("025")))
+	re.Panics(func() {
+		tree.GetOverlaps(newSimpleBucketItem([]byte(""), []byte("")))
+	})
+	re.Panics(func() {
+		NewReaderPool(tree).Get().GetOverlaps(newSimpleBucketItem([]byte("012"), []byte("018")))
+	})
+	re.NotPanics(func() {
+		tree.GetOverlaps(newSimpleBucketItem([]byte("025"), []byte("030")))
+	})
+}
@@ -0,0 +1,259 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+import (
+	"bytes"
+	"container/heap"
+	"sort"
+)
+
+const cursorBatchSize = 64
+
+// Cursor iterates the items of a RangeTree in ascending order.
+// Every walk of the btree buffers a batch of consecutive items, so the forward Seek and
+// Next calls which stay in the batch cost O(log(batch)) and O(1) instead of O(log(n)).
+// The buffer is dropped once the tree is mutated. It is not thread-safe.
+type Cursor struct {
+	tree    *RangeTree
+	version uint64
+	started bool
+	// items is the buffered batch and pos is the index of the current item in it.
+	items []RangeItem
+	pos   int
+	// exhausted is true if the buffered batch reaches the last item of the tree.
+	exhausted bool
+}
+
+// NewCursor creates a cursor of the range tree, which is positioned before the first item.
+func (r *RangeTree) NewCursor() *Cursor {
+	return &Cursor{tree: r}
+}
+
+// Seek moves the cursor to the item which contains the key, or the first item after the key
+// if the key is not covered. It returns nil if there is no such item.
+func (c *Cursor) Seek(key []byte) RangeItem {
+	if c.started && c.version == c.tree.version && len(c.items) > 0 &&
+		bytes.Compare(c.items[0].GetStartKey(), key) <= 0 {
+		i := sort.Search(len(c.items), func(i int) bool {
+			endKey := c.items[i].GetEndKey()
+			return len(endKey) == 0 || bytes.Compare(endKey, key) > 0
+		})
+		if i < len(c.items) || c.exhausted {
+			c.pos = i
+			return c.current()
+		}
+	}
+	return c.fill(func(f func(item RangeItem) bool) {
+		c.tree.ScanRange(KeyRange{StartKey: key}, f)
+	})
+}
+
+// Next moves the cursor to the next item and returns it, it returns nil if there is no more item.
+// Calling Next on a new cursor returns the first item.
+func (c *Cursor) Next() RangeItem {
+	if !c.started {
+		return c.fill(c.tree.ascend)
+	}
+	if c.version != c.tree.version {
+		if cur := c.current(); cur != nil {
+			return c.fillAfter(cur)
+		}
+		return nil
+	}
+	if c.pos < len(c.items) {
+		c.pos++
+	}
+	if c.pos < len(c.items) || c.exhausted {
+		return c.current()
+	}
+	return c.fillAfter(c.items[len(c.items)-1])
+}
+
+func (c *Cursor) current() RangeItem {
+	if c.pos < len(c.items) {
+		return c.items[c.pos]
+	}
+	return nil
+}
+
+// fillAfter buffers the items after the given item and moves the cursor to the first of them.
+func (c *Cursor) fillAfter(item RangeItem) RangeItem {
+	return c.fill(func(f func(item RangeItem) bool) {
+		c.tree.ascendGreaterOrEqual(item, func(i RangeItem) bool {
+			if !item.Less(i) {
+				return true
+			}
+			return f(i)
+		})
+	})
+}
+
+// fill buffers a batch of items by the scan and moves the cursor to the first of them.
+func (c *Cursor) fill(scan func(f func(item RangeItem) bool)) RangeItem {
+	c.items, c.pos, c.started, c.version = c.items[:0], 0, true, c.tree.version
+	scan(func(item RangeItem) bool {
+		c.items = append(c.items, item)
+		return len(c.items) < cursorBatchSize
+	})
+	c.exhausted = len(c.items) < cursorBatchSize
+	return c.current()
+}
+
+// IndexCursor moves over the items of a RangeTree by the index. Like Cursor, it buffers a batch of
+// consecutive items per walk of the btree, so the sequential Next and Prev calls cost amortized O(1)
+// instead of O(log(n)) of GetAt. The buffer is reloaded at the same index once the tree is mutated.
+// It is not thread-safe.
+type IndexCursor struct {
+	tree    *RangeTree
+	version uint64
+	// index is the current index in [-1, Len()], items is the buffered batch and base is the
+	// index of its first item.
+	index int
+	items []RangeItem
+	base  int
+}
+
+// IndexCursorAt creates an index cursor positioned at the given index, which is clamped to [-1, Len()].
+func (r *RangeTree) IndexCursorAt(index int) *IndexCursor {
+	c := &IndexCursor{tree: r, index: index}
+	switch {
+	case c.index < -1:
+		c.index = -1
+	case c.index > r.Len():
+		c.index = r.Len()
+	}
+	return c
+}
+
+// Item returns the item at the current index, it returns nil if the index is out of range.
+func (c *IndexCursor) Item() RangeItem {
+	if c.index < 0 || c.index >= c.tree.Len() {
+		return nil
+	}
+	if c.version != c.tree.version || c.index < c.base || c.index >= c.base+len(c.items) {
+		c.reload()
+	}
+	return c.items[c.index-c.base]
+}
+
+// Next moves the cursor to the next index and returns the item, it returns nil if there is no more item.
+func (c *IndexCursor) Next() RangeItem {
+	if c.index < c.tree.Len() {
+		c.index++
+	}
+	if c.version == c.tree.version && len(c.items) > 0 && c.index == c.base+len(c.items) && c.index < c.tree.Len() {
+		// the tree is unchanged, so the first item of the walk is the last buffered one.
+		last, skipped := c.items[len(c.items)-1], false
+		c.items, c.base = c.items[:0], c.index
+		c.tree.ascendGreaterOrEqual(last, func(item RangeItem) bool {
+			if !skipped {
+				skipped = true
+				return true
+			}
+			c.items = append(c.items, item)
+			return len(c.items) < cursorBatchSize
+		})
+	}
+	return c.Item()
+}
+
+// Prev moves the cursor to the previous index and returns the item, it returns nil if there is no more item.
+func (c *IndexCursor) Prev() RangeItem {
+	if c.index >= 0 {
+		c.index--
+	}
+	if c.version == c.tree.version && len(c.items) > 0 && c.index == c.base-1 && c.index >= 0 {
+		first, skipped := c.items[0], false
+		c.items = c.items[:0]
+		c.tree.descendLessOrEqual(first, func(item RangeItem) bool {
+			if !skipped {
+				skipped = true
+				return true
+			}
+			c.items = append(c.items, item)
+			return len(c.items) < cursorBatchSize
+		})
+		for i, j := 0, len(c.items)-1; i < j; i, j = i+1, j-1 {
+			c.items[i], c.items[j] = c.items[j], c.items[i]
+		}
+		c.base = c.index - len(c.items) + 1
+	}
+	return c.Item()
+}
+
+// reload buffers a batch of items from the current index which must be in range.
+func (c *IndexCursor) reload() {
+	c.items, c.base, c.version = c.items[:0], c.index, c.tree.version
+	c.tree.ascendGreaterOrEqual(c.tree.GetAt(c.index), func(item RangeItem) bool {
+		c.items = append(c.items, item)
+		return len(c.items) < cursorBatchSize
+	})
+}
+
+// MergeIterate calls f for the items of all trees in ascending order of the start keys until f returns
+// false, the items with the same start key are in the order of the trees. It merges the cursors of the
+// trees by a min-heap without building a merged tree. The items of different trees are yielded as they
+// are, so they may overlap each other.
+func MergeIterate(trees []*RangeTree, f func(item RangeItem) bool) {
+	h := make(mergeHeap, 0, len(trees))
+	for i, tree := range trees {
+		cursor := tree.NewCursor()
+		if item := cursor.Next(); item != nil {
+			h = append(h, mergeEntry{item: item, cursor: cursor, order: i})
+		}
+	}
+	heap.Init(&h)
+	for len(h) > 0 {
+		if !f(h[0].item) {
+			return
+		}
+		if next := h[0].cursor.Next(); next != nil {
+			h[0].item = next
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+}
+
+type mergeEntry struct {
+	item   RangeItem
+	cursor *Cursor
+	// order is the index of the tree, which breaks the ties of the start keys.
+	order int
+}
+
+type mergeHeap []mergeEntry
+
+func (h mergeHeap) Len() int { return len(h) }
+
+func (h mergeHeap) Less(i, j int) bool {
+	if c := bytes.Compare(h[i].item.GetStartKey(), h[j].item.GetStartKey()); c != 0 {
+		return c < 0
+	}
+	return h[i].order < h[j].order
+}
+
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(mergeEntry)) }
+
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
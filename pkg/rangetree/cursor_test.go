@@ -0,0 +1,181 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursor(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	tree := NewRangeTree(2, bucketDebrisFactory)
+	cursor := tree.NewCursor()
+	re.Nil(cursor.Next())
+	re.Nil(cursor.Seek([]byte("000")))
+
+	// key range: [0000,0010], [0020,0030], ..., the count of the items is larger than the batch size.
+	itemCount := cursorBatchSize*3 + 5
+	for i := 0; i < itemCount; i++ {
+		tree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%04d", i*20)), []byte(fmt.Sprintf("%04d", i*20+10))))
+	}
+	cursor = tree.NewCursor()
+	for i := 0; i < itemCount; i++ {
+		re.Equal([]byte(fmt.Sprintf("%04d", i*20)), cursor.Next().GetStartKey())
+	}
+	re.Nil(cursor.Next())
+	re.Nil(cursor.Next())
+
+	// monotonic forward seeks to the keys inside the items and in the gaps.
+	cursor = tree.NewCursor()
+	for i := 0; i < itemCount; i++ {
+		re.Equal([]byte(fmt.Sprintf("%04d", i*20)), cursor.Seek([]byte(fmt.Sprintf("%04d", i*20+5))).GetStartKey())
+		if i+1 < itemCount {
+			re.Equal([]byte(fmt.Sprintf("%04d", i*20+20)), cursor.Seek([]byte(fmt.Sprintf("%04d", i*20+10))).GetStartKey())
+		} else {
+			re.Nil(cursor.Seek([]byte(fmt.Sprintf("%04d", i*20+10))))
+		}
+	}
+
+	// interleaved Next and Seek calls.
+	cursor = tree.NewCursor()
+	re.Equal([]byte("0100"), cursor.Seek([]byte("0095")).GetStartKey())
+	re.Equal([]byte("0120"), cursor.Next().GetStartKey())
+	re.Equal([]byte("2000"), cursor.Seek([]byte("2000")).GetStartKey())
+	re.Equal([]byte("2020"), cursor.Next().GetStartKey())
+	// seek backward
+	re.Equal([]byte("0000"), cursor.Seek([]byte("")).GetStartKey())
+	re.Equal([]byte("0020"), cursor.Next().GetStartKey())
+
+	// the mutations are observed.
+	tree.Update(newSimpleBucketItem([]byte("0030"), []byte("0035")))
+	re.Equal([]byte("0030"), cursor.Next().GetStartKey())
+	tree.Update(newSimpleBucketItem([]byte("0000"), []byte("0040")))
+	re.Equal([]byte("0000"), cursor.Seek([]byte("0036")).GetStartKey())
+	re.Equal([]byte("0040"), cursor.Next().GetStartKey())
+}
+
+func TestIndexCursor(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	tree := NewRangeTree(2, bucketDebrisFactory)
+	re.Nil(tree.IndexCursorAt(0).Item())
+	re.Nil(tree.IndexCursorAt(0).Next())
+	re.Nil(tree.IndexCursorAt(0).Prev())
+
+	itemCount := cursorBatchSize*3 + 5
+	for i := 0; i < itemCount; i++ {
+		tree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%04d", i*20)), []byte(fmt.Sprintf("%04d", i*20+10))))
+	}
+	// forward from the start and backward from the end.
+	cursor := tree.IndexCursorAt(0)
+	for i := 0; i < itemCount; i++ {
+		re.Equal(tree.GetAt(i), cursor.Item())
+		if i+1 < itemCount {
+			re.Equal(tree.GetAt(i+1), cursor.Next())
+		}
+	}
+	re.Nil(cursor.Next())
+	re.Nil(cursor.Next())
+	for i := itemCount - 1; i >= 0; i-- {
+		re.Equal(tree.GetAt(i), cursor.Prev())
+	}
+	re.Nil(cursor.Prev())
+	re.Nil(cursor.Prev())
+	re.Equal(tree.GetAt(0), cursor.Next())
+
+	// the index is clamped.
+	re.Equal(tree.GetAt(itemCount-1), tree.IndexCursorAt(itemCount+10).Prev())
+	re.Equal(tree.GetAt(0), tree.IndexCursorAt(-10).Next())
+	// backward from the middle crosses the batches.
+	cursor = tree.IndexCursorAt(cursorBatchSize * 2)
+	for i := cursorBatchSize*2 - 1; i >= 0; i-- {
+		re.Equal(tree.GetAt(i), cursor.Prev())
+	}
+
+	// the mutations are observed at the same index.
+	cursor = tree.IndexCursorAt(2)
+	re.Equal([]byte("0040"), cursor.Item().GetStartKey())
+	tree.Update(newSimpleBucketItem([]byte("0000"), []byte("0030")))
+	re.Equal([]byte("0060"), cursor.Item().GetStartKey())
+	re.Equal([]byte("0040"), cursor.Prev().GetStartKey())
+	re.Equal([]byte("0060"), cursor.Next().GetStartKey())
+}
+
+func TestMergeIterate(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	merged := func(trees []*RangeTree, limit int) []string {
+		var res []string
+		MergeIterate(trees, func(item RangeItem) bool {
+			res = append(res, string(item.GetStartKey())+"-"+string(item.GetEndKey()))
+			return len(res) < limit
+		})
+		return res
+	}
+	re.Empty(merged(nil, 100))
+	re.Empty(merged([]*RangeTree{NewRangeTree(2, bucketDebrisFactory)}, 100))
+
+	trees := make([]*RangeTree, 3)
+	for i := range trees {
+		trees[i] = NewRangeTree(2, bucketDebrisFactory)
+		// the tree i has the items [i*10+j*30, i*10+j*30+10].
+		for j := 0; j < 3; j++ {
+			start := i*10 + j*30
+			trees[i].Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", start)), []byte(fmt.Sprintf("%03d", start+10))))
+		}
+	}
+	// the overlapping items of different trees are yielded as they are.
+	trees[2].Update(newSimpleBucketItem([]byte("000"), []byte("005")))
+	trees[1].Update(newSimpleBucketItem([]byte("085"), []byte("")))
+	re.Equal([]string{
+		"000-010", "000-005", "010-020", "020-030", "030-040", "040-050",
+		"050-060", "060-070", "070-080", "080-090", "085-",
+	}, merged(trees, 100))
+	re.Equal([]string{"000-010", "000-005", "010-020"}, merged(trees, 3))
+	re.Equal([]string{"000-005", "020-030", "050-060", "080-090"}, merged(trees[2:], 100))
+}
+
+func BenchmarkSequentialGetAt(b *testing.B) {
+	tree := newIndexCursorBenchmarkTree()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < tree.Len(); j++ {
+			_ = tree.GetAt(j).GetStartKey()
+		}
+	}
+}
+
+func BenchmarkIndexCursor(b *testing.B) {
+	tree := newIndexCursorBenchmarkTree()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cursor := tree.IndexCursorAt(0)
+		for item := cursor.Item(); item != nil; item = cursor.Next() {
+			_ = item.GetStartKey()
+		}
+	}
+}
+
+func newIndexCursorBenchmarkTree() *RangeTree {
+	tree := NewRangeTree(32, bucketDebrisFactory)
+	for i := 0; i < 10000; i++ {
+		tree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%06d", i*10)), []byte(fmt.Sprintf("%06d", i*10+10))))
+	}
+	return tree
+}
@@ -0,0 +1,301 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+)
+
+// ScanGaps calls f for every uncovered key range within [start, end) in ascending order
+// until f returns false. An empty end means the window is unbounded.
+func (r *RangeTree) ScanGaps(start, end []byte, f func(gap KeyRange) bool) {
+	r.ForEachGapWithBounds(start, end, func(_, _ RangeItem, gap KeyRange) bool {
+		return f(gap)
+	})
+}
+
+// ForEachGapWithBounds is the same as ScanGaps, but also calls f with the items bounding the gap, i.e.
+// leftItem ends at the start key of the gap and rightItem starts at the end key of the gap. leftItem
+// is nil if the gap starts at the start of the window, and rightItem is nil if the gap ends at the end
+// of the window.
+func (r *RangeTree) ForEachGapWithBounds(start, end []byte, f func(leftItem, rightItem RangeItem, gap KeyRange) bool) {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return
+	}
+	// cursor is the first key which is not checked yet, nil means the rest key space is covered.
+	// left is the item ending at the cursor.
+	var left RangeItem
+	cursor, stopped := start, false
+	r.ScanRange(KeyRange{StartKey: start}, func(item RangeItem) bool {
+		if len(end) > 0 && bytes.Compare(item.GetStartKey(), end) >= 0 {
+			return false
+		}
+		if bytes.Compare(item.GetStartKey(), cursor) > 0 && !f(left, item, KeyRange{StartKey: cursor, EndKey: item.GetStartKey()}) {
+			stopped = true
+			return false
+		}
+		itemEnd := item.GetEndKey()
+		if len(itemEnd) == 0 {
+			cursor = nil
+			return false
+		}
+		if bytes.Compare(itemEnd, cursor) > 0 {
+			cursor, left = itemEnd, item
+		}
+		if len(end) > 0 && bytes.Compare(cursor, end) >= 0 {
+			cursor = nil
+			return false
+		}
+		return true
+	})
+	if !stopped && cursor != nil {
+		f(left, nil, KeyRange{StartKey: cursor, EndKey: end})
+	}
+}
+
+// ScanCoverage walks [start, end) in ascending order until either callback returns false, it calls
+// onItem for every item overlapping with the window and onGap for every uncovered key range within the
+// window, so the gaps and the items clipped to the window tile the window. An empty end means the window
+// is unbounded. The items are not clipped, so the first and the last items may exceed the window.
+func (r *RangeTree) ScanCoverage(start, end []byte, onItem func(item RangeItem) bool, onGap func(gap KeyRange) bool) {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return
+	}
+	// cursor is the first key which is not visited yet, nil means the rest window is covered.
+	cursor, stopped := start, false
+	r.ScanOverlapping(KeyRange{StartKey: start, EndKey: end}, func(item RangeItem) bool {
+		if bytes.Compare(item.GetStartKey(), cursor) > 0 && !onGap(KeyRange{StartKey: cursor, EndKey: item.GetStartKey()}) {
+			stopped = true
+			return false
+		}
+		if !onItem(item) {
+			stopped = true
+			return false
+		}
+		if cursor = item.GetEndKey(); len(cursor) == 0 {
+			cursor = nil
+			return false
+		}
+		return true
+	})
+	if !stopped && cursor != nil && (len(end) == 0 || bytes.Compare(cursor, end) < 0) {
+		onGap(KeyRange{StartKey: cursor, EndKey: end})
+	}
+}
+
+// LargestGap returns the longest uncovered key range within [start, end), the length of
+// a key range is calculated by regarding its keys as big-endian integers padded to the max
+// length of the keys of the gaps. If there are several longest gaps, the first one is returned.
+// An unbounded trailing gap is regarded as the longest. It returns false if the window is fully
+// covered.
+func (r *RangeTree) LargestGap(start, end []byte) (KeyRange, bool) {
+	var gaps []KeyRange
+	var keys [][]byte
+	r.ScanGaps(start, end, func(gap KeyRange) bool {
+		gaps = append(gaps, gap)
+		keys = append(keys, gap.StartKey, gap.EndKey)
+		return len(gap.EndKey) > 0
+	})
+	if len(gaps) == 0 {
+		return KeyRange{}, false
+	}
+	if last := gaps[len(gaps)-1]; len(last.EndKey) == 0 {
+		return last, true
+	}
+	length := maxKeyLength(keys...)
+	largest, largestLen := gaps[0], keyDistance(gaps[0].StartKey, gaps[0].EndKey, length)
+	for _, gap := range gaps[1:] {
+		if l := keyDistance(gap.StartKey, gap.EndKey, length); l.Cmp(largestLen) > 0 {
+			largest, largestLen = gap, l
+		}
+	}
+	return largest, true
+}
+
+// MinGapSize returns the length of the shortest gap between two adjacent items, the length is calculated
+// by regarding the keys as big-endian integers padded to the max length of the keys of the gaps. The
+// touching items have no gap between them, and the key ranges before the first item and after the last
+// item are not counted. It returns false if there is no such gap, e.g. there are fewer than two items or
+// all items touch each other.
+func (r *RangeTree) MinGapSize() (*big.Int, bool) {
+	var (
+		gaps    []KeyRange
+		keys    [][]byte
+		prevEnd []byte
+	)
+	r.ascend(func(item RangeItem) bool {
+		if prevEnd != nil && bytes.Compare(prevEnd, item.GetStartKey()) < 0 {
+			gaps = append(gaps, KeyRange{StartKey: prevEnd, EndKey: item.GetStartKey()})
+			keys = append(keys, prevEnd, item.GetStartKey())
+		}
+		prevEnd = item.GetEndKey()
+		// the items after an unbounded item are overlapped by it.
+		return len(prevEnd) > 0
+	})
+	if len(gaps) == 0 {
+		return nil, false
+	}
+	length := maxKeyLength(keys...)
+	minLen := keyDistance(gaps[0].StartKey, gaps[0].EndKey, length)
+	for _, gap := range gaps[1:] {
+		if l := keyDistance(gap.StartKey, gap.EndKey, length); l.Cmp(minLen) < 0 {
+			minLen = l
+		}
+	}
+	return minLen, true
+}
+
+// CoveredRuns returns the maximal covered key ranges within [start, end) in ascending order, i.e.
+// the consecutive touching items are merged into a single run, which is clipped to the window. The
+// runs and the gaps returned by ScanGaps tile the window. An empty end means the window is unbounded.
+func (r *RangeTree) CoveredRuns(start, end []byte) []KeyRange {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+	var runs []KeyRange
+	r.ScanRange(KeyRange{StartKey: start}, func(item RangeItem) bool {
+		if len(end) > 0 && bytes.Compare(item.GetStartKey(), end) >= 0 {
+			return false
+		}
+		runStart, runEnd := intersect(item, KeyRange{StartKey: start, EndKey: end})
+		if last := len(runs) - 1; last >= 0 && bytes.Equal(runs[last].EndKey, runStart) {
+			runs[last].EndKey = runEnd
+		} else {
+			runs = append(runs, KeyRange{StartKey: runStart, EndKey: runEnd})
+		}
+		return len(runEnd) > 0 && (len(end) == 0 || bytes.Compare(runEnd, end) < 0)
+	})
+	return runs
+}
+
+// FullyCoversEach returns whether every given key range is fully covered by the items without any
+// gap, in the same order with the given ranges. An empty end key means unbounded, and an empty key
+// range is regarded as covered. The ranges are sorted by the start key and checked by a shared
+// forward cursor, so it is cheaper than checking every range separately.
+func (r *RangeTree) FullyCoversEach(ranges []KeyRange) []bool {
+	order := make([]int, len(ranges))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return bytes.Compare(ranges[order[i]].StartKey, ranges[order[j]].StartKey) < 0
+	})
+	covers := make([]bool, len(ranges))
+	cursor := r.NewCursor()
+	for _, i := range order {
+		covers[i] = fullyCovers(cursor, ranges[i].StartKey, ranges[i].EndKey)
+	}
+	return covers
+}
+
+// HasGap returns whether there is any key in [start, end) not covered by the items, an empty end means
+// unbounded. It is the complement of fully covering the range and stops walking at the first gap,
+// including the one before the first item or after the last item in the range.
+func (r *RangeTree) HasGap(start, end []byte) bool {
+	return !fullyCovers(r.NewCursor(), start, end)
+}
+
+// fullyCovers returns whether [start, end) is fully covered by the items walked by the cursor.
+func fullyCovers(cursor *Cursor, start, end []byte) bool {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return true
+	}
+	item := cursor.Seek(start)
+	if item == nil || bytes.Compare(item.GetStartKey(), start) > 0 {
+		return false
+	}
+	for {
+		itemEnd := item.GetEndKey()
+		if len(itemEnd) == 0 || (len(end) > 0 && bytes.Compare(itemEnd, end) >= 0) {
+			return true
+		}
+		if item = cursor.Next(); item == nil || !bytes.Equal(item.GetStartKey(), itemEnd) {
+			return false
+		}
+	}
+}
+
+// GapContaining returns the uncovered key range containing the key, which is from the end key of
+// the item on its left (or the start of the key space) to the start key of the item on its right
+// (or unbounded). It returns false if the key is covered by an item.
+func (r *RangeTree) GapContaining(key []byte) (KeyRange, bool) {
+	var gap KeyRange
+	covered := false
+	r.descendLessOrEqual(KeyRange{StartKey: key}, func(item RangeItem) bool {
+		covered = r.contains(item, key)
+		gap.StartKey = item.GetEndKey()
+		return false
+	})
+	if covered {
+		return KeyRange{}, false
+	}
+	r.ascendGreaterOrEqual(KeyRange{StartKey: key}, func(item RangeItem) bool {
+		if bytes.Equal(item.GetStartKey(), key) {
+			return true
+		}
+		gap.EndKey = item.GetStartKey()
+		return false
+	})
+	return gap, true
+}
+
+// Fill inserts an item built by newItem for every uncovered key range within [start, end),
+// so that the window is fully covered afterwards. It returns the inserted items.
+func (r *RangeTree) Fill(start, end []byte, newItem func(gap KeyRange) RangeItem) []RangeItem {
+	var gaps []KeyRange
+	r.ScanGaps(start, end, func(gap KeyRange) bool {
+		gaps = append(gaps, gap)
+		return true
+	})
+	inserted := make([]RangeItem, 0, len(gaps))
+	for _, gap := range gaps {
+		item := newItem(gap)
+		r.Update(item)
+		inserted = append(inserted, item)
+	}
+	return inserted
+}
+
+// FillAll fills every gap between the first and the last item by Fill, so the tree becomes contiguous
+// without extending its bounds. It returns the inserted items.
+func (r *RangeTree) FillAll(newItem func(gap KeyRange) RangeItem) []RangeItem {
+	start, end, ok := r.Bounds()
+	if !ok {
+		return nil
+	}
+	return r.Fill(start, end, newItem)
+}
+
+// CoverageBitmap returns a bitset of the covered keys in [start, end) for a discrete key domain,
+// keyIndex maps a key to its index in the domain and must be monotonic. The i-th bit, stored as
+// bitmap[i/64]&(1<<(i%64)), is set if the key with index keyIndex(start)+i is covered. The end
+// must be bounded, otherwise nil is returned.
+func (r *RangeTree) CoverageBitmap(start, end []byte, keyIndex func(key []byte) uint64) []uint64 {
+	if len(end) == 0 || bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+	base, limit := keyIndex(start), keyIndex(end)
+	bitmap := make([]uint64, (limit-base+63)/64)
+	window := KeyRange{StartKey: start, EndKey: end}
+	for _, item := range r.GetOverlaps(window) {
+		itemStart, itemEnd := intersect(window, item)
+		for i := keyIndex(itemStart) - base; i < keyIndex(itemEnd)-base; i++ {
+			bitmap[i/64] |= 1 << (i % 64)
+		}
+	}
+	return bitmap
+}
@@ -0,0 +1,461 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newGapTestTree(keys ...string) *RangeTree {
+	tree := NewRangeTree(2, bucketDebrisFactory)
+	for i := 0; i+1 < len(keys); i += 2 {
+		tree.Update(newSimpleBucketItem([]byte(keys[i]), []byte(keys[i+1])))
+	}
+	return tree
+}
+
+func TestLargestGap(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	// key range: [010,020], [030,050], [090,100]
+	tree := newGapTestTree("010", "020", "030", "050", "090", "100")
+
+	gap, ok := tree.LargestGap([]byte("000"), []byte("100"))
+	re.True(ok)
+	re.Equal(KeyRange{StartKey: []byte("050"), EndKey: []byte("090")}, gap)
+	// ties return the first gap.
+	gap, ok = tree.LargestGap([]byte("000"), []byte("030"))
+	re.True(ok)
+	re.Equal(KeyRange{StartKey: []byte("000"), EndKey: []byte("010")}, gap)
+	// the leading and trailing gaps are clipped by the window.
+	gap, ok = tree.LargestGap([]byte("015"), []byte("035"))
+	re.True(ok)
+	re.Equal(KeyRange{StartKey: []byte("020"), EndKey: []byte("030")}, gap)
+	gap, ok = tree.LargestGap([]byte("040"), []byte("300"))
+	re.True(ok)
+	re.Equal(KeyRange{StartKey: []byte("100"), EndKey: []byte("300")}, gap)
+	// the unbounded trailing gap is the largest one.
+	gap, ok = tree.LargestGap([]byte("000"), []byte(""))
+	re.True(ok)
+	re.Equal(KeyRange{StartKey: []byte("100"), EndKey: []byte("")}, gap)
+	// fully covered.
+	_, ok = tree.LargestGap([]byte("030"), []byte("050"))
+	re.False(ok)
+	_, ok = tree.LargestGap([]byte("035"), []byte("040"))
+	re.False(ok)
+	_, ok = NewRangeTree(2, bucketDebrisFactory).LargestGap([]byte("100"), []byte("000"))
+	re.False(ok)
+
+	// the gaps are compared with the keys padded to the same length.
+	mixed := newGapTestTree("b", "c", "c\x00\x00\x00\x05", "d")
+	gap, ok = mixed.LargestGap([]byte("a"), []byte("e"))
+	re.True(ok)
+	re.Equal(KeyRange{StartKey: []byte("a"), EndKey: []byte("b")}, gap)
+}
+
+func TestCoveredRuns(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	runs := func(tree *RangeTree, start, end string) []string {
+		var res []string
+		for _, run := range tree.CoveredRuns([]byte(start), []byte(end)) {
+			res = append(res, string(run.StartKey)+"-"+string(run.EndKey))
+		}
+		return res
+	}
+	re.Empty(runs(NewRangeTree(2, bucketDebrisFactory), "", ""))
+
+	// a single item.
+	tree := newGapTestTree("010", "020")
+	re.Equal([]string{"010-020"}, runs(tree, "", ""))
+	re.Equal([]string{"015-020"}, runs(tree, "015", "030"))
+	re.Empty(runs(tree, "020", "030"))
+
+	// one run.
+	tree = newGapTestTree("010", "020", "020", "030", "030", "050")
+	re.Equal([]string{"010-050"}, runs(tree, "", ""))
+	re.Equal([]string{"015-025"}, runs(tree, "015", "025"))
+
+	// several runs separated by the gaps.
+	tree = newGapTestTree("010", "020", "020", "030", "040", "050", "060", "070", "070", "")
+	re.Equal([]string{"010-030", "040-050", "060-"}, runs(tree, "", ""))
+	re.Equal([]string{"025-030", "040-050", "060-080"}, runs(tree, "025", "080"))
+	re.Equal([]string{"040-050"}, runs(tree, "035", "055"))
+	re.Empty(runs(tree, "080", "070"))
+
+	// the runs and the gaps tile the window.
+	var tiles []string
+	tree.ScanGaps([]byte("005"), []byte("065"), func(gap KeyRange) bool {
+		tiles = append(tiles, string(gap.StartKey)+"-"+string(gap.EndKey))
+		return true
+	})
+	tiles = append(tiles, runs(tree, "005", "065")...)
+	sort.Strings(tiles)
+	re.Equal([]string{"005-010", "010-030", "030-040", "040-050", "050-060", "060-065"}, tiles)
+}
+
+func TestHasGap(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	re.True(NewRangeTree(2, bucketDebrisFactory).HasGap([]byte(""), []byte("")))
+	// key range: [010,020], [020,030], [030,040], [050,060]
+	tree := newGapTestTree("010", "020", "020", "030", "030", "040", "050", "060")
+	// the fully covered windows.
+	re.False(tree.HasGap([]byte("010"), []byte("040")))
+	re.False(tree.HasGap([]byte("015"), []byte("035")))
+	re.False(tree.HasGap([]byte("050"), []byte("060")))
+	// the empty window has no gap.
+	re.False(tree.HasGap([]byte("070"), []byte("070")))
+	// the leading gap.
+	re.True(tree.HasGap([]byte("005"), []byte("040")))
+	// the interior gap.
+	re.True(tree.HasGap([]byte("010"), []byte("060")))
+	// the trailing gap.
+	re.True(tree.HasGap([]byte("020"), []byte("045")))
+	re.True(tree.HasGap([]byte("050"), []byte("")))
+	re.True(tree.HasGap([]byte("070"), []byte("080")))
+
+	tree.Update(newSimpleBucketItem([]byte("040"), []byte("050")))
+	tree.Update(newSimpleBucketItem([]byte("060"), []byte("")))
+	re.False(tree.HasGap([]byte("010"), []byte("")))
+	re.True(tree.HasGap([]byte(""), []byte("")))
+}
+
+func TestForEachGapWithBounds(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	// key range: [010,020], [030,050], [090,100]
+	tree := newGapTestTree("010", "020", "030", "050", "090", "100")
+	scan := func(start, end string) []string {
+		var res []string
+		name := func(item RangeItem) string {
+			if item == nil {
+				return "nil"
+			}
+			return string(item.GetStartKey())
+		}
+		tree.ForEachGapWithBounds([]byte(start), []byte(end), func(leftItem, rightItem RangeItem, gap KeyRange) bool {
+			res = append(res, fmt.Sprintf("%s<%s-%s>%s", name(leftItem), gap.StartKey, gap.EndKey, name(rightItem)))
+			return true
+		})
+		return res
+	}
+	// the leading gap has no left item and the trailing gap has no right item.
+	re.Equal([]string{"nil<000-010>010", "010<020-030>030", "030<050-090>090", "090<100->nil"}, scan("000", ""))
+	re.Equal([]string{"010<020-030>030", "030<050-060>nil"}, scan("015", "060"))
+	re.Equal([]string{"nil<060-070>nil"}, scan("060", "070"))
+	re.Equal([]string{"nil<050-090>090"}, scan("050", "095"))
+	re.Equal([]string{"090<100-200>nil"}, scan("095", "200"))
+	re.Empty(scan("030", "050"))
+	// the gaps are the same as ScanGaps.
+	var gaps []KeyRange
+	tree.ScanGaps([]byte("000"), []byte(""), func(gap KeyRange) bool {
+		gaps = append(gaps, gap)
+		return true
+	})
+	re.Len(gaps, 4)
+
+	// stop early.
+	var count int
+	tree.ForEachGapWithBounds([]byte("000"), []byte(""), func(_, _ RangeItem, _ KeyRange) bool {
+		count++
+		return count < 2
+	})
+	re.Equal(2, count)
+}
+
+func TestScanCoverage(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	// key range: [010,020], [020,030], [040,050], [090,]
+	tree := newGapTestTree("010", "020", "020", "030", "040", "050")
+	tree.Update(newSimpleBucketItem([]byte("090"), []byte("")))
+	scan := func(start, end string, limit int) []string {
+		var res []string
+		onItem := func(item RangeItem) bool {
+			res = append(res, fmt.Sprintf("[%s-%s]", item.GetStartKey(), item.GetEndKey()))
+			return len(res) < limit
+		}
+		onGap := func(gap KeyRange) bool {
+			res = append(res, fmt.Sprintf("(%s-%s)", gap.StartKey, gap.EndKey))
+			return len(res) < limit
+		}
+		tree.ScanCoverage([]byte(start), []byte(end), onItem, onGap)
+		return res
+	}
+	re.Equal([]string{"(000-010)", "[010-020]", "[020-030]", "(030-040)", "[040-050]", "(050-090)", "[090-]"}, scan("000", "", 100))
+	// the straddling items are not clipped.
+	re.Equal([]string{"[010-020]", "[020-030]", "(030-040)", "[040-050]"}, scan("015", "045", 100))
+	re.Equal([]string{"(030-040)", "[040-050]", "(050-060)"}, scan("030", "060", 100))
+	re.Equal([]string{"(060-080)"}, scan("060", "080", 100))
+	re.Equal([]string{"[090-]"}, scan("100", "", 100))
+	re.Empty(scan("050", "050", 100))
+	// stop early by either callback.
+	re.Equal([]string{"(000-010)", "[010-020]"}, scan("000", "", 2))
+	re.Equal([]string{"(000-010)", "[010-020]", "[020-030]", "(030-040)"}, scan("000", "", 4))
+
+	// the gaps are the same as ScanGaps and the items are the same as GetOverlaps.
+	var gaps []KeyRange
+	var items []RangeItem
+	tree.ScanCoverage([]byte("005"), []byte("095"), func(item RangeItem) bool {
+		items = append(items, item)
+		return true
+	}, func(gap KeyRange) bool {
+		gaps = append(gaps, gap)
+		return true
+	})
+	var expectedGaps []KeyRange
+	tree.ScanGaps([]byte("005"), []byte("095"), func(gap KeyRange) bool {
+		expectedGaps = append(expectedGaps, gap)
+		return true
+	})
+	re.Equal(expectedGaps, gaps)
+	re.Equal(tree.GetOverlapsInRange([]byte("005"), []byte("095")), items)
+}
+
+func TestMinGapSize(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	_, ok := newGapTestTree().MinGapSize()
+	re.False(ok)
+	_, ok = newGapTestTree("\x10", "\x20").MinGapSize()
+	re.False(ok)
+	// the touching items have no gap.
+	_, ok = newGapTestTree("\x10", "\x20", "\x20", "\x30", "\x30", "").MinGapSize()
+	re.False(ok)
+
+	// the gaps: 0x10, 0, 0x03 and 0x08.
+	size, ok := newGapTestTree("\x00", "\x10", "\x20", "\x30", "\x30", "\x40", "\x43", "\x50", "\x58", "").MinGapSize()
+	re.True(ok)
+	re.Equal(int64(0x03), size.Int64())
+	// the leading and trailing key ranges are not gaps.
+	size, ok = newGapTestTree("\x10", "\x20", "\x28", "\x30", "\x30", "\x40").MinGapSize()
+	re.True(ok)
+	re.Equal(int64(0x08), size.Int64())
+	// the keys of different lengths are compared as the same length.
+	size, ok = newGapTestTree("\x10", "\x20", "\x20\x01", "\x30", "\x40", "\x50").MinGapSize()
+	re.True(ok)
+	re.Equal(int64(1), size.Int64())
+	size, ok = newGapTestTree("\x10", "\x20", "\x21", "\x30", "\x30\x00\x00\x05", "\x40").MinGapSize()
+	re.True(ok)
+	re.Equal(int64(5), size.Int64())
+}
+
+func TestFullyCoversEach(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	re.Empty(NewRangeTree(2, bucketDebrisFactory).FullyCoversEach(nil))
+	re.Equal([]bool{false, true}, NewRangeTree(2, bucketDebrisFactory).FullyCoversEach([]KeyRange{
+		{StartKey: []byte("010"), EndKey: []byte("020")},
+		{StartKey: []byte("020"), EndKey: []byte("010")},
+	}))
+	// key range: [010,020], [020,030], [040,050], [060,]
+	tree := newGapTestTree("010", "020", "020", "030", "040", "050", "060", "")
+	ranges := []KeyRange{
+		// the partially covered ranges.
+		{StartKey: []byte("045"), EndKey: []byte("065")},
+		{StartKey: []byte("005"), EndKey: []byte("015")},
+		// the fully covered ranges.
+		{StartKey: []byte("040"), EndKey: []byte("050")},
+		{StartKey: []byte("010"), EndKey: []byte("030")},
+		{StartKey: []byte("070"), EndKey: []byte("")},
+		// the uncovered ranges.
+		{StartKey: []byte("031"), EndKey: []byte("039")},
+		{StartKey: []byte("000"), EndKey: []byte("")},
+		// the fully covered ranges overlapping with the others.
+		{StartKey: []byte("015"), EndKey: []byte("025")},
+		{StartKey: []byte("012"), EndKey: []byte("018")},
+		{StartKey: []byte("060"), EndKey: []byte("")},
+		// the partially covered ranges crossing a gap.
+		{StartKey: []byte("025"), EndKey: []byte("045")},
+		{StartKey: []byte("015"), EndKey: []byte("")},
+	}
+	re.Equal([]bool{false, false, true, true, true, false, false, true, true, true, false, false}, tree.FullyCoversEach(ranges))
+}
+
+func TestGapContaining(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	// the whole key space is a gap of the empty tree.
+	gap, ok := NewRangeTree(2, bucketDebrisFactory).GapContaining([]byte("050"))
+	re.True(ok)
+	re.Equal(KeyRange{}, gap)
+	// key range: [010,020], [030,050], [050,060]
+	tree := newGapTestTree("010", "020", "030", "050", "050", "060")
+	testCases := []struct {
+		key          string
+		startKey     string
+		endKey       string
+		notContained bool
+	}{
+		{"", "", "010", false},
+		{"005", "", "010", false},
+		{"010", "", "", true},
+		{"015", "", "", true},
+		{"020", "020", "030", false},
+		{"025", "020", "030", false},
+		{"030", "", "", true},
+		{"055", "", "", true},
+		{"060", "060", "", false},
+		{"070", "060", "", false},
+	}
+	for _, testCase := range testCases {
+		gap, ok := tree.GapContaining([]byte(testCase.key))
+		re.Equal(!testCase.notContained, ok, testCase.key)
+		re.Equal(testCase.startKey, string(gap.StartKey), testCase.key)
+		re.Equal(testCase.endKey, string(gap.EndKey), testCase.key)
+	}
+	// the key range with an unbounded item has no gap after it.
+	tree.Update(newSimpleBucketItem([]byte("070"), []byte("")))
+	_, ok = tree.GapContaining([]byte("080"))
+	re.False(ok)
+}
+
+func TestFill(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	newItem := func(gap KeyRange) RangeItem {
+		return newSimpleBucketItem(gap.StartKey, gap.EndKey)
+	}
+	// key range: [010,020], [030,050], [090,100]
+	tree := newGapTestTree("010", "020", "030", "050", "090", "100")
+
+	// the window is already full.
+	re.Empty(tree.Fill([]byte("030"), []byte("050"), newItem))
+	re.Equal(3, tree.Len())
+
+	// interior gaps.
+	inserted := tree.Fill([]byte("015"), []byte("095"), newItem)
+	re.Len(inserted, 2)
+	re.Equal([]byte("020"), inserted[0].GetStartKey())
+	re.Equal([]byte("030"), inserted[0].GetEndKey())
+	re.Equal([]byte("050"), inserted[1].GetStartKey())
+	re.Equal([]byte("090"), inserted[1].GetEndKey())
+	re.Equal(5, tree.Len())
+	_, ok := tree.LargestGap([]byte("010"), []byte("100"))
+	re.False(ok)
+
+	// leading and trailing gaps.
+	inserted = tree.Fill([]byte("000"), []byte(""), newItem)
+	re.Len(inserted, 2)
+	re.Equal([]byte("000"), inserted[0].GetStartKey())
+	re.Equal([]byte("010"), inserted[0].GetEndKey())
+	re.Equal([]byte("100"), inserted[1].GetStartKey())
+	re.Empty(inserted[1].GetEndKey())
+	re.Equal(7, tree.Len())
+	_, ok = tree.LargestGap([]byte("000"), []byte(""))
+	re.False(ok)
+}
+
+func TestFillAll(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	newItem := func(gap KeyRange) RangeItem {
+		return newSimpleBucketItem(gap.StartKey, gap.EndKey)
+	}
+	re.Empty(NewRangeTree(2, bucketDebrisFactory).FillAll(newItem))
+	// key range: [010,020], [030,050], [060,070], [090,100]
+	tree := newGapTestTree("010", "020", "030", "050", "060", "070", "090", "100")
+	inserted := tree.FillAll(newItem)
+	re.Len(inserted, 3)
+	re.Equal([]byte("020"), inserted[0].GetStartKey())
+	// nothing is filled before the first item or after the last one.
+	re.Empty(tree.CoveredRuns(nil, []byte("010")))
+	re.Empty(tree.CoveredRuns([]byte("100"), nil))
+	re.Equal([]KeyRange{{StartKey: []byte("010"), EndKey: []byte("100")}}, tree.CoveredRuns(nil, nil))
+	re.Equal(7, tree.Len())
+	re.Empty(tree.FillAll(newItem))
+
+	// the unbounded last item.
+	tree = newGapTestTree("010", "020", "030", "")
+	re.Len(tree.FillAll(newItem), 1)
+	re.Equal([]KeyRange{{StartKey: []byte("010")}}, tree.CoveredRuns(nil, nil))
+}
+
+func TestCoverageBitmap(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	keyIndex := func(key []byte) uint64 {
+		var index uint64
+		for _, b := range key {
+			index = index<<8 | uint64(b)
+		}
+		return index
+	}
+	tree := NewRangeTree(2, bucketDebrisFactory)
+	re.Equal([]uint64{0}, tree.CoverageBitmap([]byte{0x00}, []byte{0x10}, keyIndex))
+
+	// contiguous coverage
+	tree.Update(newSimpleBucketItem([]byte{0x04}, []byte{0x08}))
+	tree.Update(newSimpleBucketItem([]byte{0x08}, []byte{0x0c}))
+	re.Equal([]uint64{0x0ff0}, tree.CoverageBitmap([]byte{0x00}, []byte{0x10}, keyIndex))
+	re.Equal([]uint64{0x07f}, tree.CoverageBitmap([]byte{0x05}, []byte{0x10}, keyIndex))
+	re.Equal([]uint64{0x03}, tree.CoverageBitmap([]byte{0x0a}, []byte{0x0c}, keyIndex))
+
+	// sparse coverage over several words
+	tree.Update(newSimpleBucketItem([]byte{0x40}, []byte{0x41}))
+	tree.Update(newSimpleBucketItem([]byte{0x7f}, []byte{}))
+	re.Equal([]uint64{0x0ff0, 0x8000000000000001, 0xff}, tree.CoverageBitmap([]byte{0x00}, []byte{0x88}, keyIndex))
+
+	// the unbounded or empty window
+	re.Nil(tree.CoverageBitmap([]byte{0x00}, []byte{}, keyIndex))
+	re.Nil(tree.CoverageBitmap([]byte{0x10}, []byte{0x10}, keyIndex))
+}
+
+func TestScanGaps(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	// key range: [010,020], [030,050], [090,100], [200,+inf)
+	tree := newGapTestTree("010", "020", "030", "050", "090", "100")
+	tree.Update(newSimpleBucketItem([]byte("200"), []byte("")))
+	scan := func(start, end string, limit int) []KeyRange {
+		var gaps []KeyRange
+		tree.ScanGaps([]byte(start), []byte(end), func(gap KeyRange) bool {
+			gaps = append(gaps, gap)
+			return len(gaps) < limit
+		})
+		return gaps
+	}
+	gap := func(start, end string) KeyRange {
+		return KeyRange{StartKey: []byte(start), EndKey: []byte(end)}
+	}
+
+	re.Equal([]KeyRange{gap("000", "010"), gap("020", "030"), gap("050", "090"), gap("100", "200")}, scan("000", "", 10))
+	re.Equal([]KeyRange{gap("020", "030"), gap("050", "060")}, scan("015", "060", 10))
+	re.Equal([]KeyRange{gap("150", "200")}, scan("150", "300", 10))
+	re.Empty(scan("030", "050", 10))
+	re.Empty(scan("200", "", 10))
+	re.Empty(scan("050", "050", 10))
+	// stop early
+	re.Equal([]KeyRange{gap("000", "010"), gap("020", "030")}, scan("000", "", 2))
+
+	// an empty tree has one gap of the whole window.
+	empty := NewRangeTree(2, bucketDebrisFactory)
+	var gaps []KeyRange
+	empty.ScanGaps([]byte(""), []byte(""), func(gap KeyRange) bool {
+		gaps = append(gaps, gap)
+		return true
+	})
+	re.Equal([]KeyRange{gap("", "")}, gaps)
+
+	// the largest gap is one of the scanned gaps.
+	largest, ok := tree.LargestGap([]byte("000"), []byte("150"))
+	re.True(ok)
+	re.Contains(scan("000", "150", 10), largest)
+}
@@ -0,0 +1,152 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/tikv/pd/pkg/btree"
+)
+
+var _ RangeItem = KeyRange{}
+
+// KeyRange is the key range [StartKey, EndKey), an empty EndKey means unbounded.
+// It implements RangeItem so that it can be used to query the tree by keys directly.
+type KeyRange struct {
+	StartKey []byte
+	EndKey   []byte
+}
+
+// GetStartKey returns the start key of the key range.
+func (k KeyRange) GetStartKey() []byte {
+	return k.StartKey
+}
+
+// GetEndKey returns the end key of the key range.
+func (k KeyRange) GetEndKey() []byte {
+	return k.EndKey
+}
+
+// Less returns true if the start key of the key range is less than the start key of the argument.
+func (k KeyRange) Less(than btree.Item) bool {
+	return bytes.Compare(k.StartKey, than.(RangeItem).GetStartKey()) < 0
+}
+
+// Overlap returns true if the key ranges [start, end) of the two items intersect,
+// an empty end key means unbounded.
+func Overlap(a, b RangeItem) bool {
+	return (len(a.GetEndKey()) == 0 || bytes.Compare(a.GetEndKey(), b.GetStartKey()) > 0) &&
+		(len(b.GetEndKey()) == 0 || bytes.Compare(b.GetEndKey(), a.GetStartKey()) > 0)
+}
+
+// AreAdjacent returns true if a is immediately followed by b without any gap or overlap,
+// i.e. the end key of a equals the start key of b. An unbounded a is never adjacent to b.
+func AreAdjacent(a, b RangeItem) bool {
+	return len(a.GetEndKey()) > 0 && bytes.Equal(a.GetEndKey(), b.GetStartKey())
+}
+
+// ExclusiveEnd converts an inclusive end key to the exclusive end key used by the tree, it is
+// the canonical way to bridge the inclusive key ranges before inserting or querying. The last
+// byte is increased with carry so that it works for the fixed-length keys, and the end key with
+// all 0xFF bytes is converted to the empty end key which means unbounded.
+func ExclusiveEnd(inclusiveEnd []byte) []byte {
+	end := append([]byte(nil), inclusiveEnd...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0xFF {
+			end[i]++
+			return end
+		}
+		end[i] = 0
+	}
+	return nil
+}
+
+// InclusiveEnd is the inverse of ExclusiveEnd, the last byte is decreased with borrow.
+// It returns nil if the exclusive end key is empty (unbounded) or all zero bytes since
+// there is no inclusive end key with the same length.
+func InclusiveEnd(exclusiveEnd []byte) []byte {
+	end := append([]byte(nil), exclusiveEnd...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] != 0 {
+			end[i]--
+			return end
+		}
+		end[i] = 0xFF
+	}
+	return nil
+}
+
+// prefixEnd returns the least key greater than all keys with the prefix, which is nil (unbounded)
+// if the prefix is empty or all 0xFF bytes.
+func prefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xFF {
+			end := append([]byte(nil), prefix[:i+1]...)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+// keyDistance returns the length of [startKey, endKey), both keys are regarded as big-endian
+// unsigned integers after being right-padded with zeros to the given length, which must not be less
+// than the lengths of the keys. The lengths are only comparable if they are calculated with the same
+// length, see maxKeyLength.
+func keyDistance(startKey, endKey []byte, length int) *big.Int {
+	return new(big.Int).Sub(keyToInt(endKey, length), keyToInt(startKey, length))
+}
+
+// maxKeyLength returns the max length of the keys, which is the common length to pad the keys to
+// before comparing the lengths of their key ranges.
+func maxKeyLength(keys ...[]byte) int {
+	length := 0
+	for _, key := range keys {
+		if len(key) > length {
+			length = len(key)
+		}
+	}
+	return length
+}
+
+// shiftKey returns the key increased by the distance, both are regarded as big-endian unsigned
+// integers of the given length like keyDistance. It returns nil if the result overflows the length.
+func shiftKey(key []byte, distance *big.Int, length int) []byte {
+	sum := new(big.Int).Add(keyToInt(key, length), distance)
+	if sum.Sign() < 0 || sum.BitLen() > length*8 {
+		return nil
+	}
+	return sum.FillBytes(make([]byte, length))
+}
+
+func keyToInt(key []byte, length int) *big.Int {
+	buf := make([]byte, length)
+	copy(buf, key)
+	return new(big.Int).SetBytes(buf)
+}
+
+// intersect returns the intersection of the key ranges of the two overlapped items,
+// the returned end key is empty if the intersection is unbounded.
+func intersect(a, b RangeItem) (startKey, endKey []byte) {
+	startKey, endKey = a.GetStartKey(), a.GetEndKey()
+	if bytes.Compare(b.GetStartKey(), startKey) > 0 {
+		startKey = b.GetStartKey()
+	}
+	if e := b.GetEndKey(); len(endKey) == 0 || (len(e) > 0 && bytes.Compare(e, endKey) < 0) {
+		endKey = e
+	}
+	return startKey, endKey
+}
@@ -0,0 +1,143 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyDistance(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	re.Equal(int64(0), keyDistance([]byte("a"), []byte("a"), 1).Int64())
+	re.Equal(int64(1), keyDistance([]byte("a"), []byte("b"), 1).Int64())
+	re.Equal(int64(-1), keyDistance([]byte("b"), []byte("a"), 1).Int64())
+	re.Equal(int64(256), keyDistance([]byte{0x01, 0xff}, []byte{0x02, 0xff}, 2).Int64())
+	// the shorter key is right-padded with zeros.
+	re.Equal(int64(0), keyDistance([]byte{0x01}, []byte{0x01, 0x00}, 2).Int64())
+	re.Equal(int64(1), keyDistance([]byte{}, []byte{0x01}, 1).Int64())
+	re.Equal(int64(0x0f), keyDistance([]byte{0x01}, []byte{0x01, 0x0f}, 2).Int64())
+	// the same key range is longer when padded to a longer length.
+	re.Equal(int64(256), keyDistance([]byte{0x01}, []byte{0x02}, 2).Int64())
+	re.Equal(3, maxKeyLength([]byte{0x01}, nil, []byte{0x01, 0x02, 0x03}))
+	re.Zero(maxKeyLength())
+}
+
+func TestExclusiveEnd(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	testCases := []struct {
+		inclusiveEnd []byte
+		exclusiveEnd []byte
+	}{
+		{[]byte{0x01}, []byte{0x02}},
+		{[]byte("a"), []byte("b")},
+		{[]byte{0x01, 0xFF}, []byte{0x02, 0x00}},
+		{[]byte{0x01, 0xFF, 0xFF}, []byte{0x02, 0x00, 0x00}},
+		{[]byte{0xFF, 0xFE}, []byte{0xFF, 0xFF}},
+		{[]byte{0x00, 0x00}, []byte{0x00, 0x01}},
+	}
+	for _, testCase := range testCases {
+		inclusiveEnd := append([]byte(nil), testCase.inclusiveEnd...)
+		re.Equal(testCase.exclusiveEnd, ExclusiveEnd(inclusiveEnd))
+		re.Equal(testCase.inclusiveEnd, InclusiveEnd(testCase.exclusiveEnd))
+		// the input is not modified.
+		re.Equal(testCase.inclusiveEnd, inclusiveEnd)
+	}
+	// all 0xFF bytes are mapped to the unbounded end key.
+	re.Empty(ExclusiveEnd([]byte{0xFF}))
+	re.Empty(ExclusiveEnd([]byte{0xFF, 0xFF, 0xFF}))
+	re.Empty(ExclusiveEnd(nil))
+	// there is no inclusive end key for the unbounded or all zero end key.
+	re.Nil(InclusiveEnd(nil))
+	re.Nil(InclusiveEnd([]byte{0x00, 0x00}))
+}
+
+func TestOverlap(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	testCases := []struct {
+		a, b    KeyRange
+		overlap bool
+	}{
+		// touching
+		{KeyRange{[]byte("010"), []byte("020")}, KeyRange{[]byte("020"), []byte("030")}, false},
+		// disjoint
+		{KeyRange{[]byte("010"), []byte("020")}, KeyRange{[]byte("030"), []byte("040")}, false},
+		// nested
+		{KeyRange{[]byte("010"), []byte("040")}, KeyRange{[]byte("020"), []byte("030")}, true},
+		// staggered
+		{KeyRange{[]byte("010"), []byte("030")}, KeyRange{[]byte("020"), []byte("040")}, true},
+		// unbounded
+		{KeyRange{[]byte("010"), []byte("")}, KeyRange{[]byte("020"), []byte("030")}, true},
+		{KeyRange{[]byte("030"), []byte("")}, KeyRange{[]byte("010"), []byte("030")}, false},
+		{KeyRange{[]byte("030"), []byte("")}, KeyRange{[]byte("010"), []byte("")}, true},
+		{KeyRange{[]byte(""), []byte("")}, KeyRange{[]byte("010"), []byte("020")}, true},
+	}
+	for _, testCase := range testCases {
+		re.Equal(testCase.overlap, Overlap(testCase.a, testCase.b))
+		re.Equal(testCase.overlap, Overlap(testCase.b, testCase.a))
+	}
+}
+
+func TestAreAdjacent(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	testCases := []struct {
+		a, b     KeyRange
+		adjacent bool
+	}{
+		// touching
+		{KeyRange{[]byte("010"), []byte("020")}, KeyRange{[]byte("020"), []byte("030")}, true},
+		{KeyRange{[]byte(""), []byte("020")}, KeyRange{[]byte("020"), []byte("")}, true},
+		{KeyRange{[]byte("020"), []byte("030")}, KeyRange{[]byte("010"), []byte("020")}, false},
+		// overlapping
+		{KeyRange{[]byte("010"), []byte("025")}, KeyRange{[]byte("020"), []byte("030")}, false},
+		// gapped
+		{KeyRange{[]byte("010"), []byte("020")}, KeyRange{[]byte("021"), []byte("030")}, false},
+		// unbounded
+		{KeyRange{[]byte("010"), []byte("")}, KeyRange{[]byte(""), []byte("030")}, false},
+		{KeyRange{[]byte("010"), []byte("")}, KeyRange{[]byte("020"), []byte("030")}, false},
+	}
+	for _, testCase := range testCases {
+		re.Equal(testCase.adjacent, AreAdjacent(testCase.a, testCase.b))
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	re.Equal([]byte("ab"), prefixEnd([]byte("aa")))
+	re.Equal([]byte{0x02}, prefixEnd([]byte{0x01, 0xff}))
+	re.Equal([]byte{0x01, 0x01}, prefixEnd([]byte{0x01, 0x00}))
+	re.Nil(prefixEnd([]byte{0xff, 0xff}))
+	re.Nil(prefixEnd(nil))
+}
+
+func TestShiftKey(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	re.Equal([]byte("b"), shiftKey([]byte("a"), big.NewInt(1), 1))
+	re.Equal([]byte{0x02, 0x00}, shiftKey([]byte{0x01, 0xff}, big.NewInt(1), 2))
+	re.Equal([]byte{0x01, 0x0f}, shiftKey([]byte{0x01}, big.NewInt(0x0f), 2))
+	re.Equal([]byte{0x01, 0x00}, shiftKey([]byte{0x01, 0x00}, big.NewInt(0), 2))
+	re.Equal([]byte{0x00}, shiftKey([]byte{0x01}, big.NewInt(-1), 1))
+	// the overflows.
+	re.Nil(shiftKey([]byte{0xff}, big.NewInt(1), 1))
+	re.Nil(shiftKey([]byte{0x00}, big.NewInt(-1), 1))
+}
@@ -16,7 +16,13 @@ package rangetree
 
 import (
 	"bytes"
+	"context"
+	"math/big"
+	"sort"
+	"sync"
+	"sync/atomic"
 
+	"github.com/pingcap/errors"
 	"github.com/tikv/pd/pkg/btree"
 )
 
@@ -31,38 +37,335 @@ type RangeItem interface {
 type DebrisFactory func(startKey, EndKey []byte, item RangeItem) []RangeItem
 
 // RangeTree is the tree contains RangeItems.
+// The items are ordered by RangeItem.Less, i.e. by their start keys, so an item replaces the one with
+// the same start key, and Find, GetOverlaps and Remove locate the items by the start keys only.
 type RangeTree struct {
-	tree    *btree.BTree
-	factory DebrisFactory
+	// findHits and findMisses count the overlap queries by whether they start in an item when
+	// opStatsEnabled is set, see FindMissRate. They are accessed atomically and kept first for the
+	// 64-bit alignment.
+	findHits   uint64
+	findMisses uint64
+	// opStats counts the visited items of the queries when opStatsEnabled is set, see OpStats.
+	opStats        OpStats
+	opStatsEnabled bool
+	tree           *btree.BTree
+	degree         int
+	factory        DebrisFactory
+	// version is increased by every mutation of the tree.
+	version uint64
+	// deferredDelete makes Remove only mark the item as a tombstone, the tombstones are
+	// keyed by the start key and skipped by the queries until Compact deletes them.
+	deferredDelete bool
+	tombstones     map[string]struct{}
+	// overlapHistogram counts the updates by the count of their overlaps, see UpdateOverlapHistogram.
+	overlapHistogram []int
+	// allowPoints makes the zero-length items valid point markers, see SetAllowPoints.
+	allowPoints bool
+	// debrisObserver is called with the debris built by the factory, see SetDebrisObserver.
+	debrisObserver func(src RangeItem, children []RangeItem)
+	// capacity is the maximum count of the items, 0 means no limit.
+	capacity int
+	evict    func(tree *RangeTree) RangeItem
+	// changeLog records the latest changes, see EnableChangeLog.
+	changeLog *changeLog
 }
 
 // NewRangeTree is the constructor of the range tree.
 func NewRangeTree(degree int, factory DebrisFactory) *RangeTree {
 	return &RangeTree{
 		tree:    btree.New(degree),
+		degree:  degree,
 		factory: factory,
 	}
 }
 
-// Update insert the item and delete overlaps.
+// NewRangeTreeWithCapacity creates a range tree which is expected to hold about expectedItems items.
+// The freelist of the underlying btree is filled with the preallocated nodes, which are used by the
+// inserts before allocating any new node, and it keeps the nodes freed by the deletions for the later
+// inserts. Every node except the root holds at least degree-1 items, so expectedItems/(degree-1)+1
+// nodes are enough to hold all items, and the freelist is never smaller than btree.DefaultFreeListSize.
+// It panics with a degree less than 2 like btree.New.
+func NewRangeTreeWithCapacity(degree int, factory DebrisFactory, expectedItems int) *RangeTree {
+	if degree <= 1 {
+		panic("bad degree")
+	}
+	size := expectedItems/(degree-1) + 1
+	if size < btree.DefaultFreeListSize {
+		size = btree.DefaultFreeListSize
+	}
+	return &RangeTree{
+		tree:    btree.NewWithFreeList(degree, btree.NewPreallocatedFreeList(size, degree)),
+		degree:  degree,
+		factory: factory,
+	}
+}
+
+// Update insert the item and delete overlaps. The overlaps enclosed by the item are deleted
+// without calling the factory since they have no debris.
 func (r *RangeTree) Update(item RangeItem) []RangeItem {
-	overlaps := r.GetOverlaps(item)
+	overlaps, _ := r.UpdateWithDebris(item)
+	return overlaps
+}
+
+// LoadFromChan updates the tree with the items received from the channel in order like Update until
+// the channel is closed, so the items need not be buffered by the caller. The items are not reordered
+// since a later item overrides the earlier overlapping ones. It returns the error of the context if
+// the context is done before the channel is closed, the received items are kept in the tree.
+func (r *RangeTree) LoadFromChan(ctx context.Context, ch <-chan RangeItem) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case item, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			r.Update(item)
+		}
+	}
+}
+
+// ApplyDelta applies a batch of changes to the tree, it removes the items with the same start keys as
+// the deletes first, and then updates the tree with the inserts like Update, both in ascending order of
+// the start keys for better locality. So an insert always survives a delete of the same key range, and
+// of the overlapping inserts the one with the greater start key wins, the ones with the same start key
+// are applied in the given order. It returns all overlaps displaced by the inserts, including the
+// displaced inserts. The given slices are not modified.
+func (r *RangeTree) ApplyDelta(inserts []RangeItem, deletes []RangeItem) (displaced []RangeItem) {
+	byStartKey := func(items []RangeItem) []RangeItem {
+		sorted := append([]RangeItem(nil), items...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return bytes.Compare(sorted[i].GetStartKey(), sorted[j].GetStartKey()) < 0
+		})
+		return sorted
+	}
+	for _, item := range byStartKey(deletes) {
+		r.Remove(item)
+	}
+	for _, item := range byStartKey(inserts) {
+		displaced = append(displaced, r.Update(item)...)
+	}
+	return displaced
+}
+
+// GetOrInsert returns the item with the same start key as the given item without modifying the tree
+// if there is one, otherwise it updates the tree with the given item like Update and returns it with
+// inserted being true.
+func (r *RangeTree) GetOrInsert(item RangeItem) (actual RangeItem, inserted bool) {
+	if existing := r.get(item); existing != nil {
+		return existing, false
+	}
+	if covering := r.coveringRange(item); covering != nil {
+		return covering, false
+	}
+	r.Update(item)
+	return item, true
+}
+
+// InsertContiguousAfter updates the tree with newItem like Update if it starts exactly at the end of
+// prev, which must be in the tree with the same key range, and it does not overlap with any item, i.e.
+// it fills the gap after prev without displacing anything. Otherwise it returns an error without
+// modifying the tree, so the contiguity bugs of an ordered construction are caught at the insertion.
+func (r *RangeTree) InsertContiguousAfter(prev RangeItem, newItem RangeItem) error {
+	stored := r.get(prev)
+	if stored == nil || !bytes.Equal(stored.GetEndKey(), prev.GetEndKey()) {
+		return errors.Errorf("item [%q, %q) is not in the tree", prev.GetStartKey(), prev.GetEndKey())
+	}
+	if len(prev.GetEndKey()) == 0 || !bytes.Equal(prev.GetEndKey(), newItem.GetStartKey()) {
+		return errors.Errorf("item [%q, %q) does not start at the end of item [%q, %q)",
+			newItem.GetStartKey(), newItem.GetEndKey(), prev.GetStartKey(), prev.GetEndKey())
+	}
+	if len(newItem.GetEndKey()) > 0 && bytes.Compare(newItem.GetStartKey(), newItem.GetEndKey()) >= 0 &&
+		!(r.allowPoints && isPoint(newItem)) {
+		return errors.Errorf("invalid item [%q, %q)", newItem.GetStartKey(), newItem.GetEndKey())
+	}
+	var err error
+	r.ScanOverlapping(newItem, func(over RangeItem) bool {
+		err = errors.Errorf("item [%q, %q) overlaps with item [%q, %q)",
+			newItem.GetStartKey(), newItem.GetEndKey(), over.GetStartKey(), over.GetEndKey())
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	r.Update(newItem)
+	return nil
+}
+
+// UpdateWithDebris is the same as Update, but also returns the debris generated
+// by the factory that are inserted into the tree.
+func (r *RangeTree) UpdateWithDebris(item RangeItem) (overlaps []RangeItem, debris []RangeItem) {
+	if r.coveringRange(item) != nil {
+		return nil, nil
+	}
+	overlaps = r.GetOverlaps(item)
 	for _, old := range overlaps {
-		r.tree.Delete(old)
-		children := r.factory(item.GetStartKey(), item.GetEndKey(), old)
-		for _, child := range children {
-			if c := bytes.Compare(child.GetStartKey(), child.GetEndKey()); c < 0 {
-				r.tree.ReplaceOrInsert(child)
-			} else if c > 0 && len(child.GetEndKey()) == 0 {
-				r.tree.ReplaceOrInsert(child)
+		debris = r.clip(old, item.GetStartKey(), item.GetEndKey(), debris)
+	}
+	r.insert(item)
+	r.version++
+	r.observeOverlaps(len(overlaps))
+	r.evictOverCapacity()
+	return overlaps, debris
+}
+
+// OverlapAction is how UpdateWithPolicy handles an overlap of the updated item.
+type OverlapAction int
+
+const (
+	// OverlapSplit clips the overlap by the factory like Update.
+	OverlapSplit OverlapAction = iota
+	// OverlapDelete deletes the whole overlap without calling the factory.
+	OverlapDelete
+	// OverlapSkip keeps the overlap as it is.
+	OverlapSkip
+)
+
+// UpdateWithPolicy is the same as Update, but the policy decides how every overlap is handled and
+// only the split or deleted overlaps are returned. The skipped overlaps are kept overlapping with the
+// item, which breaks the invariant of the tree that the items are disjoint, so the queries may miss
+// some overlaps (or panic with the rangetree_debug build tag) and Validate reports an error until they
+// are fixed. An overlap with the same start key as the item cannot be kept and is deleted even if it
+// is skipped.
+func (r *RangeTree) UpdateWithPolicy(item RangeItem, policy func(overlap RangeItem) OverlapAction) []RangeItem {
+	if r.coveringRange(item) != nil {
+		return nil
+	}
+	var overlaps []RangeItem
+	for _, old := range r.GetOverlaps(item) {
+		action := policy(old)
+		if action == OverlapSkip && bytes.Equal(old.GetStartKey(), item.GetStartKey()) {
+			action = OverlapDelete
+		}
+		switch action {
+		case OverlapSkip:
+			continue
+		case OverlapDelete:
+			r.delete(old)
+			if r.debrisObserver != nil {
+				r.debrisObserver(old, nil)
 			}
+		default:
+			r.clip(old, item.GetStartKey(), item.GetEndKey(), nil)
 		}
+		overlaps = append(overlaps, old)
 	}
-	r.tree.ReplaceOrInsert(item)
+	r.insert(item)
+	r.version++
+	r.observeOverlaps(len(overlaps))
+	r.evictOverCapacity()
 	return overlaps
 }
 
+// clip replaces the old item with its debris outside [startKey, endKey) built by the factory, and
+// appends the inserted debris to the given slice.
+func (r *RangeTree) clip(old RangeItem, startKey, endKey []byte, debris []RangeItem) []RangeItem {
+	r.delete(old)
+	if enclosed(old, startKey, endKey) {
+		if r.debrisObserver != nil {
+			r.debrisObserver(old, nil)
+		}
+		return debris
+	}
+	children := r.factory(startKey, endKey, old)
+	if r.debrisObserver != nil {
+		r.debrisObserver(old, children)
+	}
+	for _, child := range children {
+		if r.validDebris(child) {
+			r.insert(child)
+			debris = append(debris, child)
+		}
+	}
+	return debris
+}
+
+// validDebris returns true if the debris built by the factory can be inserted, i.e. it is not
+// inverted or empty unless it is a point marker in the point mode.
+func (r *RangeTree) validDebris(child RangeItem) bool {
+	c := bytes.Compare(child.GetStartKey(), child.GetEndKey())
+	return c < 0 || (c > 0 && len(child.GetEndKey()) == 0) || (c == 0 && r.allowPoints && isPoint(child))
+}
+
+// SetDebrisObserver sets the function called once per overlapped item clipped by Update or
+// RemovePrefix, with all children built by the factory before the invalid ones like the zero-length
+// children are dropped. The children are nil for the overlapped item enclosed by the update since
+// the factory is not called. A nil observer disables it.
+func (r *RangeTree) SetDebrisObserver(observer func(src RangeItem, children []RangeItem)) {
+	r.debrisObserver = observer
+}
+
+// enclosed returns true if the item is inside [startKey, endKey), an empty end key means unbounded.
+func enclosed(item RangeItem, startKey, endKey []byte) bool {
+	return bytes.Compare(item.GetStartKey(), startKey) >= 0 &&
+		(len(endKey) == 0 || (len(item.GetEndKey()) > 0 && bytes.Compare(item.GetEndKey(), endKey) <= 0))
+}
+
+// SetCapacity sets the maximum count of the items, 0 means no limit. Once an update makes the tree
+// exceed the capacity, the item chosen by evict is removed until the tree is within the capacity
+// again, or evict returns nil or an item which is not in the tree.
+func (r *RangeTree) SetCapacity(maxCount int, evict func(tree *RangeTree) RangeItem) {
+	r.capacity, r.evict = maxCount, evict
+	r.evictOverCapacity()
+}
+
+func (r *RangeTree) evictOverCapacity() {
+	for r.capacity > 0 && r.Len() > r.capacity {
+		item := r.evict(r)
+		if item == nil || r.Remove(item) == nil {
+			return
+		}
+	}
+}
+
+// TrimToCapacity removes the items furthest from the pivot until the tree holds at most maxCount items,
+// and returns the removed items in the order of removal. The distance of an item is the length of the
+// gap between it and the pivot, which is 0 if the item contains the pivot, and the lengths are
+// calculated by regarding the keys as big-endian integers. Of the items with the same distance the one
+// with the greater key is removed first. As the distances grow away from the pivot in both directions,
+// only the first and the last items are compared for every removal.
+func (r *RangeTree) TrimToCapacity(maxCount int, pivot []byte) []RangeItem {
+	var evicted []RangeItem
+	for r.Len() > maxCount && r.Len() > 0 {
+		var first, last RangeItem
+		r.ascend(func(item RangeItem) bool {
+			first = item
+			return false
+		})
+		r.descend(func(item RangeItem) bool {
+			last = item
+			return false
+		})
+		// the distances are compared as the keys of the same length.
+		length := len(pivot)
+		for _, key := range [][]byte{first.GetStartKey(), first.GetEndKey(), last.GetStartKey(), last.GetEndKey()} {
+			if len(key) > length {
+				length = len(key)
+			}
+		}
+		if distanceToKey(first, pivot, length).Cmp(distanceToKey(last, pivot, length)) > 0 {
+			last = first
+		}
+		r.Remove(last)
+		evicted = append(evicted, last)
+	}
+	return evicted
+}
+
+// distanceToKey returns the length of the gap between the item and the key like keyDistance with the
+// given length, which is 0 if the item contains the key or ends at it.
+func distanceToKey(item RangeItem, key []byte, length int) *big.Int {
+	if bytes.Compare(item.GetStartKey(), key) > 0 {
+		return new(big.Int).Sub(keyToInt(item.GetStartKey(), length), keyToInt(key, length))
+	}
+	if end := item.GetEndKey(); len(end) > 0 && bytes.Compare(end, key) < 0 {
+		return new(big.Int).Sub(keyToInt(key, length), keyToInt(end, length))
+	}
+	return new(big.Int)
+}
+
 // GetOverlaps returns the range items that has some intersections with the given items.
+// An empty start key is the minimum key and an empty end key means unbounded.
 func (r *RangeTree) GetOverlaps(item RangeItem) []RangeItem {
 	// note that Find() gets the last item that is less or equal than the item.
 	// in the case: |_______a_______|_____b_____|___c___|
@@ -70,38 +373,479 @@ func (r *RangeTree) GetOverlaps(item RangeItem) []RangeItem {
 	// Find() will return RangeItem of item_a
 	// and both startKey of item_a and item_b are less than endKey of item_d,
 	// thus they are regarded as overlapped items.
-	result := r.Find(item)
+	var buf []RangeItem
+	if p, ok := overlapsPool.Get().(*[]RangeItem); ok {
+		buf = *p
+	}
+	overlaps := r.appendOverlaps(buf, item, -1)
+	if len(overlaps) == 0 {
+		PutOverlaps(overlaps)
+		return nil
+	}
+	return overlaps
+}
+
+var overlapsPool sync.Pool
+
+// PutOverlaps gives back the slice returned by RangeTree.GetOverlaps (or Update) for reuse to reduce
+// the allocations, which is optional. The slice must not be used after calling PutOverlaps. The slices
+// returned by CachedRangeTree.GetOverlaps and Reader.GetOverlaps are still owned by them, so they must
+// never be passed to PutOverlaps.
+func PutOverlaps(overlaps []RangeItem) {
+	if cap(overlaps) == 0 {
+		return
+	}
+	for i := range overlaps {
+		overlaps[i] = nil
+	}
+	overlaps = overlaps[:0]
+	overlapsPool.Put(&overlaps)
+}
+
+// appendOverlaps appends at most n overlaps of the given item to dst and returns the extended slice,
+// a non-positive n means no limit.
+func (r *RangeTree) appendOverlaps(dst []RangeItem, item RangeItem, n int) []RangeItem {
+	start := len(dst)
+	r.ScanOverlapping(item, func(over RangeItem) bool {
+		dst = append(dst, over)
+		return len(dst)-start != n
+	})
+	checkOverlaps(dst[start:])
+	return dst
+}
+
+// ScanOverlapping calls f for the range items that has some intersections with the given item in
+// ascending order until f returns false, it is the streaming version of GetOverlaps with the same
+// boundaries. The item straddling the start key of the given item is the first one, and the scan
+// stops before the first item starting at or after the end key of the given item.
+func (r *RangeTree) ScanOverlapping(item RangeItem, f func(over RangeItem) bool) {
+	result := r.findCounted(item, &r.opStats.OverlapVisits)
+	if r.opStatsEnabled {
+		if result == nil {
+			atomic.AddUint64(&r.findMisses, 1)
+		} else {
+			atomic.AddUint64(&r.findHits, 1)
+		}
+	}
 	if result == nil {
 		result = item
 	}
 
-	var overlaps []RangeItem
-	r.tree.AscendGreaterOrEqual(result, func(i btree.Item) bool {
-		over := i.(RangeItem)
-		if len(item.GetEndKey()) > 0 && bytes.Compare(item.GetEndKey(), over.GetStartKey()) <= 0 {
+	// a point marker overlaps with the item starting at the same key.
+	bound := 0
+	if r.allowPoints && isPoint(item) {
+		bound = -1
+	}
+	r.ascendGreaterOrEqual(result, r.countVisits(&r.opStats.OverlapVisits, func(over RangeItem) bool {
+		if len(item.GetEndKey()) > 0 && bytes.Compare(item.GetEndKey(), over.GetStartKey()) <= bound {
 			return false
 		}
-		overlaps = append(overlaps, over)
+		return f(over)
+	}))
+}
+
+// GetOverlapsN returns at most n range items that has some intersections with the given item in
+// ascending order, the scan stops once n items are collected. It returns nil if n <= 0.
+func (r *RangeTree) GetOverlapsN(item RangeItem, n int) []RangeItem {
+	if n <= 0 {
+		return nil
+	}
+	return r.appendOverlaps(nil, item, n)
+}
+
+// GetOverlapsReverse calls f for the range items that has some intersections with the given item
+// in descending order until f returns false. Like GetOverlaps, the item straddling the start key of
+// the given item is included as the last one.
+func (r *RangeTree) GetOverlapsReverse(item RangeItem, f func(RangeItem) bool) {
+	startKey, endKey := item.GetStartKey(), item.GetEndKey()
+	// a point marker overlaps with the item starting at the same key.
+	bound := 0
+	if r.allowPoints && isPoint(item) {
+		bound = 1
+	}
+	visit := func(over RangeItem) bool {
+		if len(endKey) > 0 && bytes.Compare(over.GetStartKey(), endKey) >= bound {
+			return true
+		}
+		if bytes.Compare(over.GetStartKey(), startKey) >= 0 {
+			return f(over)
+		}
+		// the items before the left straddler never overlap with the given item.
+		if len(over.GetEndKey()) == 0 || bytes.Compare(over.GetEndKey(), startKey) > 0 {
+			f(over)
+		}
+		return false
+	}
+	if len(endKey) == 0 {
+		r.descend(visit)
+		return
+	}
+	r.descendLessOrEqual(KeyRange{StartKey: endKey}, visit)
+}
+
+// GetOverlapsInRange returns the range items that has some intersections with [start, end).
+func (r *RangeTree) GetOverlapsInRange(start, end []byte) []RangeItem {
+	return r.GetOverlaps(KeyRange{StartKey: start, EndKey: end})
+}
+
+// BoundedLen returns the count of the range items that has some intersections with [start, end)
+// without allocating, it is the counting version of GetOverlapsInRange. Note it uses the overlap
+// semantics, so the item straddling the start key is counted, unlike the count of the items whose
+// start keys are in [start, end), e.g. by AscendRange.
+func (r *RangeTree) BoundedLen(start, end []byte) int {
+	count := 0
+	r.ScanOverlapping(KeyRange{StartKey: start, EndKey: end}, func(RangeItem) bool {
+		count++
 		return true
 	})
-	return overlaps
+	return count
+}
+
+// GetOverlapsInclusive returns the range items that has some intersections with [start, end],
+// the end key is converted by ExclusiveEnd, so an end key of all 0xFF bytes means unbounded.
+func (r *RangeTree) GetOverlapsInclusive(start, end []byte) []RangeItem {
+	return r.GetOverlapsInRange(start, ExclusiveEnd(end))
+}
+
+// GetOverlapsBatch returns the overlaps of every given item in the same order with the given items.
+// The items are sorted by the start key and share a forward cursor, so it is cheaper than calling
+// GetOverlaps for every item. In the point mode it calls GetOverlaps for every item instead, since
+// the cursor does not stop at the point markers.
+func (r *RangeTree) GetOverlapsBatch(items []RangeItem) [][]RangeItem {
+	if r.allowPoints {
+		results := make([][]RangeItem, len(items))
+		for i, item := range items {
+			results[i] = r.GetOverlaps(item)
+		}
+		return results
+	}
+	order := make([]int, len(items))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return bytes.Compare(items[order[i]].GetStartKey(), items[order[j]].GetStartKey()) < 0
+	})
+	results := make([][]RangeItem, len(items))
+	cursor := r.NewCursor()
+	for _, i := range order {
+		endKey := items[i].GetEndKey()
+		for over := cursor.Seek(items[i].GetStartKey()); over != nil; over = cursor.Next() {
+			if len(endKey) > 0 && bytes.Compare(endKey, over.GetStartKey()) <= 0 {
+				break
+			}
+			results[i] = append(results[i], over)
+		}
+	}
+	return results
+}
+
+// Intersects returns true if any item intersects with [start, end), an empty end means unbounded.
+// It stops at the first intersected item. In the point mode [start, start) is a point query.
+func (r *RangeTree) Intersects(start, end []byte) bool {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 && !(r.allowPoints && bytes.Equal(start, end)) {
+		return false
+	}
+	query := KeyRange{StartKey: start, EndKey: end}
+	if r.Find(query) != nil {
+		return true
+	}
+	found := false
+	r.ascendGreaterOrEqual(query, func(item RangeItem) bool {
+		found = len(end) == 0 || bytes.Compare(item.GetStartKey(), end) < 0
+		return false
+	})
+	return found
+}
+
+// OverlapBoundaries returns the overlaps of the given item grouped by how they cross its boundaries.
+// leftStraddler crosses the start key and rightStraddler crosses the end key of the given item,
+// they are the same item if it encloses the given item. fullyInside contains the other overlaps.
+func (r *RangeTree) OverlapBoundaries(item RangeItem) (leftStraddler, rightStraddler RangeItem, fullyInside []RangeItem) {
+	for _, over := range r.GetOverlaps(item) {
+		inside := true
+		if bytes.Compare(over.GetStartKey(), item.GetStartKey()) < 0 {
+			leftStraddler, inside = over, false
+		}
+		if endKey := item.GetEndKey(); len(endKey) > 0 &&
+			(len(over.GetEndKey()) == 0 || bytes.Compare(over.GetEndKey(), endKey) > 0) {
+			rightStraddler, inside = over, false
+		}
+		if inside {
+			fullyInside = append(fullyInside, over)
+		}
+	}
+	return leftStraddler, rightStraddler, fullyInside
+}
+
+// ContainedBy returns the items which are entirely inside [start, end), an empty end means unbounded.
+// The items straddling the boundaries of the window are excluded.
+func (r *RangeTree) ContainedBy(start, end []byte) []RangeItem {
+	var items []RangeItem
+	r.ascendGreaterOrEqual(KeyRange{StartKey: start}, func(item RangeItem) bool {
+		if len(end) == 0 {
+			items = append(items, item)
+			return true
+		}
+		if bytes.Compare(item.GetStartKey(), end) >= 0 {
+			return false
+		}
+		if itemEnd := item.GetEndKey(); len(itemEnd) > 0 && bytes.Compare(itemEnd, end) <= 0 {
+			items = append(items, item)
+		}
+		return true
+	})
+	return items
+}
+
+// DominantOverlap returns the overlap which has the longest intersection with the given item, where
+// the keys of all intersections are padded to the same length to make their lengths comparable. An
+// unbounded intersection is longer than any bounded one, and the first one is returned if there are
+// several longest intersections. It returns nil if there is no overlap.
+func (r *RangeTree) DominantOverlap(item RangeItem) RangeItem {
+	overlaps := r.GetOverlaps(item)
+	if len(overlaps) == 0 {
+		return nil
+	}
+	intersections := make([]KeyRange, 0, len(overlaps))
+	keys := make([][]byte, 0, 2*len(overlaps))
+	for _, over := range overlaps {
+		startKey, endKey := intersect(item, over)
+		if len(endKey) == 0 {
+			return over
+		}
+		intersections = append(intersections, KeyRange{StartKey: startKey, EndKey: endKey})
+		keys = append(keys, startKey, endKey)
+	}
+	length := maxKeyLength(keys...)
+	dominant, dominantLen := 0, keyDistance(intersections[0].StartKey, intersections[0].EndKey, length)
+	for i, kr := range intersections[1:] {
+		if l := keyDistance(kr.StartKey, kr.EndKey, length); l.Cmp(dominantLen) > 0 {
+			dominant, dominantLen = i+1, l
+		}
+	}
+	return overlaps[dominant]
+}
+
+// ItemCoverage is an overlapping item with the fraction of its key range inside a query window.
+type ItemCoverage struct {
+	Item     RangeItem
+	Fraction float64
+}
+
+// OverlapCoverage returns the overlaps of the given item with the fractions of their key ranges inside
+// the given item, i.e. the length of the intersection divided by the length of the overlap, where the
+// lengths are calculated by regarding the keys as big-endian integers. The fraction of an unbounded
+// overlap is always 0 as its length is infinite, and that of an empty (point) overlap is 1.
+func (r *RangeTree) OverlapCoverage(item RangeItem) []ItemCoverage {
+	overlaps := r.GetOverlaps(item)
+	coverages := make([]ItemCoverage, 0, len(overlaps))
+	for _, over := range overlaps {
+		coverage := ItemCoverage{Item: over}
+		if overEnd := over.GetEndKey(); len(overEnd) > 0 {
+			coverage.Fraction = 1
+			startKey, endKey := intersect(item, over)
+			// all keys are extended to the same length to make the lengths comparable.
+			length := len(over.GetStartKey())
+			for _, key := range [][]byte{overEnd, startKey, endKey} {
+				if len(key) > length {
+					length = len(key)
+				}
+			}
+			overLen := new(big.Int).Sub(keyToInt(overEnd, length), keyToInt(over.GetStartKey(), length))
+			if overLen.Sign() > 0 {
+				interLen := new(big.Int).Sub(keyToInt(endKey, length), keyToInt(startKey, length))
+				coverage.Fraction, _ = new(big.Float).Quo(new(big.Float).SetInt(interLen), new(big.Float).SetInt(overLen)).Float64()
+			}
+		}
+		coverages = append(coverages, coverage)
+	}
+	return coverages
+}
+
+// OverlapLength returns the total length of the parts of the given item covered by the items, where
+// the lengths are calculated by regarding the keys as big-endian integers of the same length, so the
+// gaps between the overlaps are not counted. It returns nil if the covered part is unbounded, i.e. both
+// the given item and its last overlap are unbounded.
+func (r *RangeTree) OverlapLength(item RangeItem) *big.Int {
+	overlaps := r.GetOverlaps(item)
+	intersections := make([]KeyRange, 0, len(overlaps))
+	keys := make([][]byte, 0, 2*len(overlaps))
+	for _, over := range overlaps {
+		startKey, endKey := intersect(item, over)
+		if len(endKey) == 0 {
+			return nil
+		}
+		intersections = append(intersections, KeyRange{StartKey: startKey, EndKey: endKey})
+		keys = append(keys, startKey, endKey)
+	}
+	length := maxKeyLength(keys...)
+	total := new(big.Int)
+	for _, kr := range intersections {
+		total.Add(total, keyDistance(kr.StartKey, kr.EndKey, length))
+	}
+	return total
 }
 
 // Find returns the range item contains the start key.
 func (r *RangeTree) Find(item RangeItem) RangeItem {
+	return r.findCounted(item, &r.opStats.FindVisits)
+}
+
+// findCounted is Find counting the visited items to the given counter of opStats.
+func (r *RangeTree) findCounted(item RangeItem, visits *uint64) RangeItem {
 	var result RangeItem
-	r.tree.DescendLessOrEqual(item, func(i btree.Item) bool {
-		result = i.(RangeItem)
+	r.descendLessOrEqual(item, r.countVisits(visits, func(i RangeItem) bool {
+		result = i
 		return false
-	})
+	}))
 
-	if result == nil || !contains(result, item.GetStartKey()) {
+	if result == nil || !r.contains(result, item.GetStartKey()) {
 		return nil
 	}
 
 	return result
 }
 
+// FindAllContaining returns all items containing the key in ascending order, it is the same as Find
+// for a valid tree but finds every item covering the key in a tree with overlaps, e.g. the skipped
+// overlaps of UpdateWithPolicy. As an item containing the key can be anywhere before the key in such
+// a tree, it walks all items before the key and costs O(n).
+func (r *RangeTree) FindAllContaining(key []byte) []RangeItem {
+	var items []RangeItem
+	r.descendLessOrEqual(KeyRange{StartKey: key}, func(item RangeItem) bool {
+		if r.contains(item, key) {
+			items = append(items, item)
+		}
+		return true
+	})
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	return items
+}
+
+// FindStrict returns the item containing the key, i.e. its start key <= key < its end key, where an
+// empty end key means unbounded. So the item ending at the key is never returned, neither is the item
+// after the key when the key is in a gap. It is the same as Find with the key except that the point
+// markers contain no key and are never returned.
+func (r *RangeTree) FindStrict(key []byte) RangeItem {
+	var result RangeItem
+	r.descendLessOrEqual(KeyRange{StartKey: key}, func(item RangeItem) bool {
+		if contains(item, key) {
+			result = item
+		}
+		return false
+	})
+	return result
+}
+
+// HasStartKey returns true if there is an item which starts with the given key.
+// The items are ordered by the start key, so inserting an item with the same start key
+// replaces the existing one silently. Callers can check it before Update if needed.
+func (r *RangeTree) HasStartKey(startKey []byte) bool {
+	item := r.tree.Get(KeyRange{StartKey: startKey})
+	return item != nil && !r.isTombstone(item.(RangeItem))
+}
+
+// KNearest returns at most k items which are nearest to the key in ascending order of the distance.
+// The distance is the one between the key and the nearest key of the item by regarding the keys as
+// big-endian integers padded to the max length of the keys of the candidates, so it is 0 for the item
+// containing the key. The lower item comes first if two items have the same distance.
+func (r *RangeTree) KNearest(key []byte, k int) []RangeItem {
+	if k <= 0 {
+		return nil
+	}
+	// the distance of a candidate is the length of [from, to), plus 1 for the exclusive end key of the
+	// item before the key. The distances are calculated after collecting all candidates so that the keys
+	// are padded to the same length.
+	type candidate struct {
+		item      RangeItem
+		from, to  []byte
+		exclusive bool
+		distance  *big.Int
+	}
+	var left, right []candidate
+	keys := [][]byte{key}
+	pivot := KeyRange{StartKey: key}
+	r.descendLessOrEqual(pivot, func(item RangeItem) bool {
+		c := candidate{item: item, from: key, to: key}
+		if !r.contains(item, key) {
+			c.from, c.exclusive = item.GetEndKey(), true
+			keys = append(keys, c.from)
+		}
+		left = append(left, c)
+		return len(left) < k
+	})
+	r.ascendGreaterOrEqual(pivot, func(item RangeItem) bool {
+		if bytes.Equal(item.GetStartKey(), key) {
+			return true
+		}
+		right = append(right, candidate{item: item, from: key, to: item.GetStartKey()})
+		keys = append(keys, item.GetStartKey())
+		return len(right) < k
+	})
+	length := maxKeyLength(keys...)
+	for i := range left {
+		left[i].distance = keyDistance(left[i].from, left[i].to, length)
+		if left[i].exclusive {
+			left[i].distance.Add(left[i].distance, big.NewInt(1))
+		}
+	}
+	for i := range right {
+		right[i].distance = keyDistance(right[i].from, right[i].to, length)
+	}
+	nearest := make([]RangeItem, 0, k)
+	for len(nearest) < k && (len(left) > 0 || len(right) > 0) {
+		if len(right) == 0 || (len(left) > 0 && left[0].distance.Cmp(right[0].distance) <= 0) {
+			nearest, left = append(nearest, left[0].item), left[1:]
+		} else {
+			nearest, right = append(nearest, right[0].item), right[1:]
+		}
+	}
+	return nearest
+}
+
+// SetAllowPoints sets whether the zero-length items whose start key equals the end key are valid
+// point markers. A point marker contains the key equal to its start key, so it can be found by Find,
+// and the debris of point markers are kept by Update. Because the items are ordered by the start
+// key, a point marker cannot be kept together with the range item starting with the same key, e.g.
+// the right debris of a range split by the point marker. So a point marker whose key is contained by
+// a range item is rejected: Update, UpdateWithDebris and UpdateWithPolicy leave the tree unchanged and
+// return nil, and GetOrInsert returns the range item. A point marker can still be put in a gap or at
+// the exclusive end key of a range, and it is replaced by a range covering it.
+func (r *RangeTree) SetAllowPoints(allow bool) {
+	r.allowPoints = allow
+}
+
+// coveringRange returns the range item containing the key of the given point marker in the point
+// mode, which rejects the point marker, see SetAllowPoints. It returns nil otherwise.
+func (r *RangeTree) coveringRange(item RangeItem) RangeItem {
+	if !r.allowPoints || !isPoint(item) {
+		return nil
+	}
+	var covering RangeItem
+	r.descendLessOrEqual(item, func(i RangeItem) bool {
+		covering = i
+		return false
+	})
+	if covering == nil || isPoint(covering) || !contains(covering, item.GetStartKey()) {
+		return nil
+	}
+	return covering
+}
+
+func (r *RangeTree) contains(item RangeItem, key []byte) bool {
+	if r.allowPoints && isPoint(item) {
+		return bytes.Equal(item.GetStartKey(), key)
+	}
+	return contains(item, key)
+}
+
+func isPoint(item RangeItem) bool {
+	return len(item.GetEndKey()) > 0 && bytes.Equal(item.GetStartKey(), item.GetEndKey())
+}
+
 func contains(item RangeItem, key []byte) bool {
 	start, end := item.GetStartKey(), item.GetEndKey()
 	return bytes.Compare(key, start) >= 0 && (len(end) == 0 || bytes.Compare(key, end) < 0)
@@ -109,58 +853,834 @@ func contains(item RangeItem, key []byte) bool {
 
 // Remove removes the given item and return the deleted item.
 func (r *RangeTree) Remove(item RangeItem) RangeItem {
-	if r := r.tree.Delete(item); r != nil {
-		return r.(RangeItem)
+	if r.deferredDelete {
+		ret := r.get(item)
+		if ret == nil {
+			return nil
+		}
+		if r.tombstones == nil {
+			r.tombstones = make(map[string]struct{})
+		}
+		r.tombstones[string(item.GetStartKey())] = struct{}{}
+		r.recordChange(ChangeDelete, ret)
+		r.version++
+		return ret
+	}
+	if ret := r.delete(item); ret != nil {
+		r.version++
+		return ret
 	}
 	return nil
 }
 
+// RemoveRange removes all items overlapping with [start, end) and returns the removed items,
+// an empty end key means unbounded.
+func (r *RangeTree) RemoveRange(start, end []byte) []RangeItem {
+	overlaps := r.GetOverlapsInRange(start, end)
+	for _, item := range overlaps {
+		r.Remove(item)
+	}
+	return overlaps
+}
+
+// RemoveRangeInclusive removes all items overlapping with [start, end] and returns the removed items,
+// the end key is converted by ExclusiveEnd, so an end key of all 0xFF bytes means unbounded.
+func (r *RangeTree) RemoveRangeInclusive(start, end []byte) []RangeItem {
+	return r.RemoveRange(start, ExclusiveEnd(end))
+}
+
+// RemovePrefix removes the key range [prefix, prefixEnd) from the tree, where prefixEnd is the least
+// key greater than all keys with the prefix, or unbounded if the prefix is all 0xFF bytes. The items
+// straddling the boundaries are clipped by the factory so only their parts inside the key range are
+// removed. It returns the overlapped items before clipping.
+func (r *RangeTree) RemovePrefix(prefix []byte) []RangeItem {
+	end := prefixEnd(prefix)
+	overlaps := r.GetOverlapsInRange(prefix, end)
+	for _, old := range overlaps {
+		r.clip(old, prefix, end, nil)
+	}
+	if len(overlaps) > 0 {
+		r.version++
+	}
+	return overlaps
+}
+
+// Prune removes the items whose lengths are less than minLen and returns the removed items, where the
+// length is calculated by regarding the keys as big-endian integers padded to the max length of the keys
+// in the tree (see keyWidth), so minLen is measured in the units of the longest key and a longer key in
+// the tree makes all items longer. The unbounded items are never removed. It is used to clean up the tiny
+// debris left by the repeated updates.
+func (r *RangeTree) Prune(minLen *big.Int) []RangeItem {
+	length := r.keyWidth()
+	return r.Retain(func(item RangeItem) bool {
+		return len(item.GetEndKey()) == 0 || keyDistance(item.GetStartKey(), item.GetEndKey(), length).Cmp(minLen) >= 0
+	})
+}
+
+// keyWidth returns the max length of the start and end keys of the items, which is the common length
+// to pad the keys to before comparing the lengths of the items. It costs O(n).
+func (r *RangeTree) keyWidth() int {
+	length := 0
+	r.ascend(func(item RangeItem) bool {
+		if l := maxKeyLength(item.GetStartKey(), item.GetEndKey()); l > length {
+			length = l
+		}
+		return true
+	})
+	return length
+}
+
+// Retain keeps only the items satisfying pred and removes the others, the removed items are returned
+// in ascending order. The items are collected before removing, so pred is called without mutating the tree.
+func (r *RangeTree) Retain(pred func(item RangeItem) bool) []RangeItem {
+	var removed []RangeItem
+	r.ascend(func(item RangeItem) bool {
+		if !pred(item) {
+			removed = append(removed, item)
+		}
+		return true
+	})
+	for _, item := range removed {
+		r.Remove(item)
+	}
+	return removed
+}
+
+// SetDeferredDelete sets whether to defer the deletions of Remove. In the deferred mode, Remove
+// only marks the item as a tombstone which is skipped by the queries, and Compact deletes all
+// tombstones from the btree at once to reduce the rebalancing under heavy deletions. Note the
+// tombstones keep holding their memory until Compact, and the index based queries like GetAt
+// cost O(n) while there are tombstones. Turning off the deferred mode compacts the tree.
+func (r *RangeTree) SetDeferredDelete(deferred bool) {
+	r.deferredDelete = deferred
+	if !deferred {
+		r.Compact()
+	}
+}
+
+// Compact deletes all tombstones from the btree, and rebuilds the btree with the same degree to fill
+// its nodes as much as possible, which improves the cache locality after many deletions. It costs
+// O(n), see FillFactor to decide whether it is worthwhile.
+func (r *RangeTree) Compact() {
+	for startKey := range r.tombstones {
+		r.tree.Delete(KeyRange{StartKey: []byte(startKey)})
+	}
+	r.tombstones = nil
+	r.tree.Rebuild()
+}
+
+// Resize rebuilds the btree with the new degree like Compact, and returns the structural stats before
+// and after the rebuilding to evaluate the degree. The new degree must be at least 2. It costs O(n*log(n)).
+func (r *RangeTree) Resize(newDegree int) (oldStats, newStats TreeStats, err error) {
+	if newDegree < 2 {
+		return TreeStats{}, TreeStats{}, errors.Errorf("invalid degree %d", newDegree)
+	}
+	oldStats = r.treeStats()
+	tree := btree.New(newDegree)
+	r.ascend(func(item RangeItem) bool {
+		tree.ReplaceOrInsert(item)
+		return true
+	})
+	tree.Rebuild()
+	r.tree, r.degree, r.tombstones = tree, newDegree, nil
+	return oldStats, r.treeStats(), nil
+}
+
+// FillFactor returns the ratio of the items to the capacity of the btree nodes in (0, 1], the
+// tombstones are counted as items. A low fill factor means the nodes are sparsely filled. It returns
+// 1 for an empty tree and costs O(n/degree).
+func (r *RangeTree) FillFactor() float64 {
+	nodes := r.tree.NodeCount()
+	if nodes == 0 {
+		return 1
+	}
+	return float64(r.tree.Len()) / float64(nodes*(2*r.degree-1))
+}
+
 // Len returns the count of the range tree.
 func (r *RangeTree) Len() int {
-	return r.tree.Len()
+	return r.tree.Len() - len(r.tombstones)
+}
+
+// UnboundedCount returns the count of the items with an empty end key.
+// A valid tree has at most one such item, which is the last one.
+func (r *RangeTree) UnboundedCount() int {
+	return r.Count(func(item RangeItem) bool {
+		return len(item.GetEndKey()) == 0
+	})
+}
+
+// Count returns the count of the items satisfying pred, it walks the whole tree without collecting them.
+func (r *RangeTree) Count(pred func(item RangeItem) bool) int {
+	count := 0
+	r.ascend(func(item RangeItem) bool {
+		if pred(item) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// Bounds returns the start key of the first item and the end key of the last item, which is
+// empty if the last item is unbounded. ok is false if the tree is empty.
+func (r *RangeTree) Bounds() (startKey, endKey []byte, ok bool) {
+	r.ascend(func(item RangeItem) bool {
+		startKey, ok = item.GetStartKey(), true
+		return false
+	})
+	r.descend(func(item RangeItem) bool {
+		endKey = item.GetEndKey()
+		return false
+	})
+	return startKey, endKey, ok
+}
+
+// Clamp returns the intersection of [start, end) and the bounds of the tree (see Bounds), an empty
+// end key means unbounded, and the returned end key is empty only if both are unbounded. ok is false
+// if the tree is empty or the intersection is empty.
+func (r *RangeTree) Clamp(start, end []byte) (cs, ce []byte, ok bool) {
+	startKey, endKey, ok := r.Bounds()
+	if !ok {
+		return nil, nil, false
+	}
+	cs, ce = intersect(KeyRange{StartKey: start, EndKey: end}, KeyRange{StartKey: startKey, EndKey: endKey})
+	if len(ce) > 0 && bytes.Compare(cs, ce) >= 0 {
+		return nil, nil, false
+	}
+	return cs, ce, true
+}
+
+// CompactSlice returns all items in ascending order, and the consecutive items which touch each
+// other and are equal by the given function are merged into one item by merge. It does not modify
+// the tree.
+func (r *RangeTree) CompactSlice(equal func(a, b RangeItem) bool, merge func(a, b RangeItem) RangeItem) []RangeItem {
+	var items []RangeItem
+	r.ascend(func(item RangeItem) bool {
+		if n := len(items); n > 0 && AreAdjacent(items[n-1], item) && equal(items[n-1], item) {
+			items[n-1] = merge(items[n-1], item)
+		} else {
+			items = append(items, item)
+		}
+		return true
+	})
+	return items
+}
+
+// CoalesceAll replaces every maximal run of the touching items with a single item built by newItem
+// for the key range of the run regardless of the items themselves, and returns the count of the
+// removed items, i.e. the count of the items minus the count of the runs.
+func (r *RangeTree) CoalesceAll(newItem func(merged KeyRange) RangeItem) int {
+	var runs [][]RangeItem
+	r.ascend(func(item RangeItem) bool {
+		if n := len(runs); n > 0 && AreAdjacent(runs[n-1][len(runs[n-1])-1], item) {
+			runs[n-1] = append(runs[n-1], item)
+		} else {
+			runs = append(runs, []RangeItem{item})
+		}
+		return true
+	})
+	removed := 0
+	for _, run := range runs {
+		if len(run) == 1 {
+			continue
+		}
+		for _, item := range run {
+			r.Remove(item)
+		}
+		r.insert(newItem(KeyRange{StartKey: run[0].GetStartKey(), EndKey: run[len(run)-1].GetEndKey()}))
+		r.version++
+		removed += len(run) - 1
+	}
+	return removed
+}
+
+// Swap exchanges the contents of the two trees in O(1), it is useful to build a tree in the background
+// and then swap it in. Both versions are increased beyond each other to invalidate the cached states.
+// Callers must ensure there is no concurrent access to the two trees during the swap.
+func (r *RangeTree) Swap(other *RangeTree) {
+	r.tree, other.tree = other.tree, r.tree
+	r.degree, other.degree = other.degree, r.degree
+	r.factory, other.factory = other.factory, r.factory
+	r.tombstones, other.tombstones = other.tombstones, r.tombstones
+	version := r.version
+	if other.version > version {
+		version = other.version
+	}
+	r.version, other.version = version+1, version+1
+	r.resetChangeLog()
+	other.resetChangeLog()
+}
+
+// Fork returns two copy-on-write clones of the tree which share the structure with it lazily, so
+// the mutations of the tree and the forks do not affect each other. Fork should not be called
+// concurrently with the mutations of the tree, but the tree and the forks can be used concurrently
+// once it returns.
+func (r *RangeTree) Fork() (*RangeTree, *RangeTree) {
+	return r.clone(), r.clone()
+}
+
+// clone returns a copy-on-write clone of the tree with the same options, the statistics are not cloned.
+func (r *RangeTree) clone() *RangeTree {
+	c := &RangeTree{
+		tree:           r.tree.Clone(),
+		degree:         r.degree,
+		factory:        r.factory,
+		version:        r.version,
+		deferredDelete: r.deferredDelete,
+		allowPoints:    r.allowPoints,
+		opStatsEnabled: r.opStatsEnabled,
+		debrisObserver: r.debrisObserver,
+		capacity:       r.capacity,
+		evict:          r.evict,
+	}
+	if len(r.tombstones) > 0 {
+		c.tombstones = make(map[string]struct{}, len(r.tombstones))
+		for startKey := range r.tombstones {
+			c.tombstones[startKey] = struct{}{}
+		}
+	}
+	return c
+}
+
+// RebaseKeys returns a new tree with the same degree and factory, which contains the items built by
+// newItem with the start and end keys converted by transform. The transform must preserve the order
+// of the keys, otherwise the new items may be reordered and overlap each other. Note an empty end key
+// is unbounded and is not passed to transform.
+func (r *RangeTree) RebaseKeys(transform func(key []byte) []byte, newItem func(src RangeItem, newStart, newEnd []byte) RangeItem) *RangeTree {
+	tree := NewRangeTree(r.degree, r.factory)
+	r.ascend(func(item RangeItem) bool {
+		newEnd := item.GetEndKey()
+		if len(newEnd) > 0 {
+			newEnd = transform(newEnd)
+		}
+		tree.Update(newItem(item, transform(item.GetStartKey()), newEnd))
+		return true
+	})
+	return tree
+}
+
+// Partition splits the items into n new trees with the same degree and factory, every tree holds a
+// contiguous run of the items and the counts of the items differ by at most one, so some trees are
+// empty if n is greater than Len. The items are shared and not split. It returns nil if n is not positive.
+func (r *RangeTree) Partition(n int) []*RangeTree {
+	if n <= 0 {
+		return nil
+	}
+	items := make([]RangeItem, 0, r.Len())
+	r.ascend(func(item RangeItem) bool {
+		items = append(items, item)
+		return true
+	})
+	trees := make([]*RangeTree, n)
+	for i := range trees {
+		trees[i] = NewRangeTree(r.degree, r.factory)
+		for _, item := range items[i*len(items)/n : (i+1)*len(items)/n] {
+			trees[i].insert(item)
+		}
+	}
+	return trees
+}
+
+// Flatten returns the key ranges of all items in ascending order without the items themselves, which
+// can be restored by InflateCoverage, e.g. to be transferred through RPC.
+func (r *RangeTree) Flatten() []KeyRange {
+	ranges := make([]KeyRange, 0, r.Len())
+	r.ascend(func(item RangeItem) bool {
+		ranges = append(ranges, KeyRange{StartKey: item.GetStartKey(), EndKey: item.GetEndKey()})
+		return true
+	})
+	return ranges
+}
+
+// InflateCoverage builds a tree with the given degree and factory from the key ranges returned by
+// Flatten, every item is built by newItem for its key range. It returns an error if a key range is
+// empty or the key ranges are not in ascending order without overlaps.
+func InflateCoverage(degree int, factory DebrisFactory, ranges []KeyRange, newItem func(kr KeyRange) RangeItem) (*RangeTree, error) {
+	tree := NewRangeTree(degree, factory)
+	for i, kr := range ranges {
+		if len(kr.EndKey) > 0 && bytes.Compare(kr.StartKey, kr.EndKey) >= 0 {
+			return nil, errors.Errorf("invalid key range [%q, %q)", kr.StartKey, kr.EndKey)
+		}
+		if i > 0 {
+			if prev := ranges[i-1]; len(prev.EndKey) == 0 || bytes.Compare(prev.EndKey, kr.StartKey) > 0 {
+				return nil, errors.Errorf("key range [%q, %q) is not after key range [%q, %q)",
+					kr.StartKey, kr.EndKey, prev.StartKey, prev.EndKey)
+			}
+		}
+		tree.insert(newItem(kr))
+	}
+	return tree, nil
+}
+
+// MergeWith updates the tree with all items of the other tree, and resolve decides what occupies
+// every intersection [overlapStart, overlapEnd) of an existing item and an incoming one. The item
+// returned by resolve must cover exactly the intersection, e.g. a clipped copy of the winner, or it
+// can be nil to let the incoming item win. The other tree must not be the tree itself.
+func (r *RangeTree) MergeWith(other *RangeTree, resolve func(existing, incoming RangeItem, overlapStart, overlapEnd []byte) RangeItem) {
+	other.ascend(func(incoming RangeItem) bool {
+		overlaps := r.GetOverlaps(incoming)
+		resolved := make([]RangeItem, 0, len(overlaps))
+		for _, existing := range overlaps {
+			overlapStart, overlapEnd := intersect(existing, incoming)
+			if item := resolve(existing, incoming, overlapStart, overlapEnd); item != nil {
+				resolved = append(resolved, item)
+			}
+		}
+		PutOverlaps(overlaps)
+		// the incoming item clips the existing ones first, then the resolved items clip the incoming one.
+		r.Update(incoming)
+		for _, item := range resolved {
+			r.Update(item)
+		}
+		return true
+	})
+}
+
+// Equal returns true if the two trees have the same items, i.e. the items are pairwise of the same
+// key ranges and equal by itemEqual. It returns true in O(1) if the trees share the structure and
+// neither of them is mutated since Fork.
+func (r *RangeTree) Equal(other *RangeTree, itemEqual func(a, b RangeItem) bool) bool {
+	return r.equalBy(other, bytes.Equal, itemEqual)
+}
+
+// EqualWithin returns true if the two trees have the items of the same key ranges compared by keyEqual,
+// e.g. ignoring the trailing zero padding of the keys, and the items themselves are not compared. The
+// unbounded end key is never passed to keyEqual and only equals the unbounded end key.
+func (r *RangeTree) EqualWithin(other *RangeTree, keyEqual func(a, b []byte) bool) bool {
+	return r.equalBy(other, keyEqual, nil)
+}
+
+// equalBy compares the trees pairwise by keyEqual, and by itemEqual if it is not nil.
+func (r *RangeTree) equalBy(other *RangeTree, keyEqual func(a, b []byte) bool, itemEqual func(a, b RangeItem) bool) bool {
+	if r.Len() != other.Len() {
+		return false
+	}
+	if len(r.tombstones) == 0 && len(other.tombstones) == 0 && r.tree.SharesRoot(other.tree) {
+		return true
+	}
+	cursor := other.NewCursor()
+	equal := true
+	r.ascend(func(item RangeItem) bool {
+		o := cursor.Next()
+		equal = o != nil && keyEqual(item.GetStartKey(), o.GetStartKey()) &&
+			(len(item.GetEndKey()) == 0) == (len(o.GetEndKey()) == 0) &&
+			(len(item.GetEndKey()) == 0 || keyEqual(item.GetEndKey(), o.GetEndKey())) &&
+			(itemEqual == nil || itemEqual(item, o))
+		return equal
+	})
+	return equal
+}
+
+// EqualKeys returns true if the two trees have the items of the same key ranges, the items themselves
+// are not compared.
+func (r *RangeTree) EqualKeys(other *RangeTree) bool {
+	return r.Equal(other, func(_, _ RangeItem) bool { return true })
+}
+
+// DiffStream compares the tree with the items yielded by next in ascending order until it returns
+// false, and returns the items only in the stream as added and the items only in the tree as removed.
+// The items with the same key range are regarded as the same if itemEqual returns true, otherwise
+// they are both returned. It walks the tree and the stream together without materializing the stream.
+func (r *RangeTree) DiffStream(next func() (RangeItem, bool), itemEqual func(a, b RangeItem) bool) (added, removed []RangeItem) {
+	cursor := r.NewCursor()
+	existing := cursor.Next()
+	incoming, ok := next()
+	for existing != nil || ok {
+		if !ok {
+			removed = append(removed, existing)
+			existing = cursor.Next()
+			continue
+		}
+		if existing == nil {
+			added = append(added, incoming)
+			incoming, ok = next()
+			continue
+		}
+		switch c := bytes.Compare(existing.GetStartKey(), incoming.GetStartKey()); {
+		case c < 0:
+			removed = append(removed, existing)
+			existing = cursor.Next()
+		case c > 0:
+			added = append(added, incoming)
+			incoming, ok = next()
+		default:
+			if !bytes.Equal(existing.GetEndKey(), incoming.GetEndKey()) || !itemEqual(existing, incoming) {
+				removed = append(removed, existing)
+				added = append(added, incoming)
+			}
+			existing = cursor.Next()
+			incoming, ok = next()
+		}
+	}
+	return added, removed
+}
+
+// Degree returns the degree of the btree which the tree is constructed with.
+func (r *RangeTree) Degree() int {
+	return r.degree
+}
+
+// Version returns the version of the range tree, which is increased by every mutation.
+// The tree is unchanged if its version stays the same.
+func (r *RangeTree) Version() uint64 {
+	return r.version
 }
 
 // ScanRange scan the start item util the result of the function is false.
 func (r *RangeTree) ScanRange(start RangeItem, f func(_ RangeItem) bool) {
 	// Find if there is one item with key range [s, d), s < startKey < d
-	startItem := r.Find(start)
+	startItem := r.findCounted(start, &r.opStats.ScanRangeVisits)
 	if startItem == nil {
 		startItem = start
 	}
-	r.tree.AscendGreaterOrEqual(startItem, func(item btree.Item) bool {
-		return f(item.(RangeItem))
+	r.ascendGreaterOrEqual(startItem, r.countVisits(&r.opStats.ScanRangeVisits, f))
+}
+
+// ScanWhere is the same as ScanRange, but only calls f for the items which satisfy pred.
+func (r *RangeTree) ScanWhere(start RangeItem, pred func(item RangeItem) bool, f func(item RangeItem) bool) {
+	r.ScanRange(start, func(item RangeItem) bool {
+		return !pred(item) || f(item)
+	})
+}
+
+// AscendRange calls f for the items in [greaterOrEqual, lessThan) in ascending order until f returns false.
+// The bounding items are compared with the items by Less, which means by the start keys.
+func (r *RangeTree) AscendRange(greaterOrEqual, lessThan RangeItem, f func(item RangeItem) bool) {
+	r.tree.AscendRange(greaterOrEqual, lessThan, r.liveIterator(f))
+}
+
+// DescendRange calls f for the items in (greaterThan, lessOrEqual] in descending order until f returns false.
+// The bounding items are compared with the items by Less, which means by the start keys.
+func (r *RangeTree) DescendRange(lessOrEqual, greaterThan RangeItem, f func(item RangeItem) bool) {
+	r.tree.DescendRange(lessOrEqual, greaterThan, r.liveIterator(f))
+}
+
+// WalkBackwardFrom calls f for the items in descending order until f returns false, starting from the
+// item containing the key, or the last item before the key if the key is in a gap. f is not called if
+// the key is before all items.
+func (r *RangeTree) WalkBackwardFrom(key []byte, f func(item RangeItem) bool) {
+	r.descendLessOrEqual(KeyRange{StartKey: key}, f)
+}
+
+// ForEachFromLast calls f for at most n items in descending order starting from the last item until f
+// returns false, e.g. to show the items with the highest keys first. f is not called if n <= 0.
+func (r *RangeTree) ForEachFromLast(n int, f func(item RangeItem) bool) {
+	if n <= 0 {
+		return
+	}
+	r.descend(func(item RangeItem) bool {
+		n--
+		return f(item) && n > 0
+	})
+}
+
+// WalkWithNeighbors calls f for every item in ascending order together with its previous and next
+// items, which are nil for the first and the last item, until f returns false.
+func (r *RangeTree) WalkWithNeighbors(f func(prev, curr, next RangeItem) bool) {
+	var prev, curr RangeItem
+	stopped := false
+	r.ascend(func(next RangeItem) bool {
+		if curr != nil && !f(prev, curr, next) {
+			stopped = true
+			return false
+		}
+		prev, curr = curr, next
+		return true
 	})
+	if !stopped && curr != nil {
+		f(prev, curr, nil)
+	}
+}
+
+// TouchingNeighbors returns the neighbors of the item like GetAdjacentItem, but only the ones touching
+// the item without gap or overlap, i.e. the left one ends at the start key of the item and the right
+// one starts at the end key of the item. The side without a touching neighbor is nil.
+func (r *RangeTree) TouchingNeighbors(item RangeItem) (left, right RangeItem) {
+	prev, next := r.GetAdjacentItem(item)
+	if prev != nil && len(prev.GetEndKey()) > 0 && bytes.Equal(prev.GetEndKey(), item.GetStartKey()) {
+		left = prev
+	}
+	if next != nil && len(item.GetEndKey()) > 0 && bytes.Equal(item.GetEndKey(), next.GetStartKey()) {
+		right = next
+	}
+	return left, right
 }
 
 // GetAdjacentItem returns the adjacent range item.
 func (r *RangeTree) GetAdjacentItem(item RangeItem) (prev RangeItem, next RangeItem) {
-	r.tree.AscendGreaterOrEqual(item, func(i btree.Item) bool {
-		if bytes.Equal(item.GetStartKey(), i.(RangeItem).GetStartKey()) {
+	r.ascendGreaterOrEqual(item, func(i RangeItem) bool {
+		if bytes.Equal(item.GetStartKey(), i.GetStartKey()) {
 			return true
 		}
-		next = i.(RangeItem)
+		next = i
 		return false
 	})
-	r.tree.DescendLessOrEqual(item, func(i btree.Item) bool {
-		if bytes.Equal(item.GetStartKey(), i.(RangeItem).GetStartKey()) {
+	r.descendLessOrEqual(item, func(i RangeItem) bool {
+		if bytes.Equal(item.GetStartKey(), i.GetStartKey()) {
 			return true
 		}
-		prev = i.(RangeItem)
+		prev = i
 		return false
 	})
 	return prev, next
 }
 
-// GetAt returns the given index item.
+// Move removes the item from the tree and updates the tree with the one built by rekey which starts
+// at newStart with the same length, returning the overlaps at the new position. The length is
+// calculated like keyDistance with the old keys and newStart padded to the same length, and the new
+// end key is empty (unbounded) if the item is unbounded or the new end key overflows that length. It returns nil if the item is not in the tree.
+func (r *RangeTree) Move(item RangeItem, newStart []byte, rekey func(src RangeItem, newStart, newEnd []byte) RangeItem) []RangeItem {
+	old := r.get(item)
+	if old == nil {
+		return nil
+	}
+	var newEnd []byte
+	if oldStart, oldEnd := old.GetStartKey(), old.GetEndKey(); len(oldEnd) > 0 {
+		length := maxKeyLength(oldStart, oldEnd, newStart)
+		newEnd = shiftKey(newStart, keyDistance(oldStart, oldEnd, length), length)
+	}
+	r.Remove(old)
+	return r.Update(rekey(old, newStart, newEnd))
+}
+
+// ExtendEnd replaces the item in the tree with the one built by rekey with the greater end key, an
+// empty newEnd means unbounded. It returns false without any change if the item is not in the tree,
+// the new end key does not extend the item, or the extended item collides with the next item, i.e.
+// newEnd can be at most the start key of the next item.
+func (r *RangeTree) ExtendEnd(item RangeItem, newEnd []byte, rekey func(src RangeItem, newEnd []byte) RangeItem) bool {
+	old := r.get(item)
+	if old == nil || len(old.GetEndKey()) == 0 || (len(newEnd) > 0 && bytes.Compare(newEnd, old.GetEndKey()) <= 0) {
+		return false
+	}
+	if _, next := r.GetAdjacentItem(old); next != nil &&
+		(len(newEnd) == 0 || bytes.Compare(newEnd, next.GetStartKey()) > 0) {
+		return false
+	}
+	r.insert(rekey(old, newEnd))
+	r.version++
+	return true
+}
+
+// ShrinkTo replaces the item in the tree with the one built by rekey with the strictly smaller key
+// range [newStart, newEnd), the vacated key ranges become gaps. An empty newEnd means unbounded, which
+// is only valid for an unbounded item. It returns false without any change if the item is not in the
+// tree, or the new key range is empty, not inside the item or the same as the item.
+func (r *RangeTree) ShrinkTo(item RangeItem, newStart, newEnd []byte, rekey func(src RangeItem, newStart, newEnd []byte) RangeItem) bool {
+	old := r.get(item)
+	if old == nil {
+		return false
+	}
+	oldStart, oldEnd := old.GetStartKey(), old.GetEndKey()
+	switch {
+	case bytes.Compare(newStart, oldStart) < 0:
+		return false
+	case len(newEnd) == 0:
+		if len(oldEnd) > 0 || bytes.Equal(newStart, oldStart) {
+			return false
+		}
+	case bytes.Compare(newStart, newEnd) >= 0:
+		return false
+	case len(oldEnd) > 0 && bytes.Compare(newEnd, oldEnd) > 0:
+		return false
+	case bytes.Equal(newStart, oldStart) && bytes.Equal(newEnd, oldEnd):
+		return false
+	}
+	if !bytes.Equal(newStart, oldStart) {
+		r.delete(old)
+	}
+	r.insert(rekey(old, newStart, newEnd))
+	r.version++
+	return true
+}
+
+// GetAt returns the given index item. It returns nil if index < 0 or index >= Len(), with or without
+// the tombstones.
 func (r *RangeTree) GetAt(index int) RangeItem {
-	return r.tree.GetAt(index).(RangeItem)
+	if len(r.tombstones) > 0 {
+		var (
+			rst RangeItem
+			k   int
+		)
+		r.ascend(func(i RangeItem) bool {
+			if k == index {
+				rst = i
+				return false
+			}
+			k++
+			return true
+		})
+		return rst
+	}
+	item, _ := r.tree.GetAt(index).(RangeItem)
+	return item
+}
+
+// ItemAtQuantile returns the item at the q-th quantile position in the key order, i.e. the item at
+// the index int(q*Len()) clamped to the last index, where q is in [0, 1]. It returns nil if the
+// tree is empty.
+func (r *RangeTree) ItemAtQuantile(q float64) RangeItem {
+	count := r.Len()
+	if count == 0 {
+		return nil
+	}
+	index := int(q * float64(count))
+	switch {
+	case index < 0:
+		index = 0
+	case index >= count:
+		index = count - 1
+	}
+	return r.GetAt(index)
+}
+
+// SampleUniform returns n items at evenly spaced indexes in ascending order, i.e. the items at the
+// indexes i*Len()/n for i in [0, n). All items are returned without duplicates if n >= Len(), and nil
+// is returned if n <= 0. It costs O(n*log(Len())) unless there are tombstones, see GetAt.
+func (r *RangeTree) SampleUniform(n int) []RangeItem {
+	count := r.Len()
+	if n <= 0 || count == 0 {
+		return nil
+	}
+	if n > count {
+		n = count
+	}
+	samples := make([]RangeItem, 0, n)
+	if n == count || len(r.tombstones) > 0 {
+		index := 0
+		r.ascend(func(item RangeItem) bool {
+			if index == len(samples)*count/n {
+				samples = append(samples, item)
+			}
+			index++
+			return len(samples) < n
+		})
+		return samples
+	}
+	for i := 0; i < n; i++ {
+		samples = append(samples, r.GetAt(i*count/n))
+	}
+	return samples
+}
+
+// ScanFromIndex scans the items in ascending order from the item at the given index until f returns
+// false, a negative index is regarded as 0, and nothing is scanned if the index is not less than Len.
+func (r *RangeTree) ScanFromIndex(index int, f func(item RangeItem) bool) {
+	if index >= r.Len() {
+		return
+	}
+	start := 0
+	if index > 0 {
+		start = index
+	}
+	r.ascendGreaterOrEqual(r.GetAt(start), f)
 }
 
 // GetWithIndex returns index and item for the given item.
 func (r *RangeTree) GetWithIndex(item RangeItem) (RangeItem, int) {
+	if len(r.tombstones) > 0 {
+		var (
+			rst   RangeItem
+			index int
+		)
+		r.ascend(func(i RangeItem) bool {
+			if !i.Less(item) {
+				if !item.Less(i) {
+					rst = i
+				}
+				return false
+			}
+			index++
+			return true
+		})
+		return rst, index
+	}
 	rst, index := r.tree.GetWithIndex(item)
 	if rst == nil {
 		return nil, index
 	}
 	return rst.(RangeItem), index
 }
+
+// IndexOf returns the index of the item which contains the key. If the key is not covered, it
+// returns -(i+1) where i is the index at which an item starting with the key would be inserted,
+// so the result is always negative in this case.
+func (r *RangeTree) IndexOf(key []byte) int {
+	if item := r.Find(KeyRange{StartKey: key}); item != nil {
+		_, index := r.GetWithIndex(item)
+		return index
+	}
+	_, index := r.GetWithIndex(KeyRange{StartKey: key})
+	return -(index + 1)
+}
+
+// Rank returns the count of the items whose start keys are less than the key, the item starting
+// with the key does not precede it. It costs O(log(n)) unless there are tombstones, see GetWithIndex.
+func (r *RangeTree) Rank(key []byte) int {
+	_, index := r.GetWithIndex(KeyRange{StartKey: key})
+	return index
+}
+
+func (r *RangeTree) insert(item RangeItem) {
+	if old := r.tree.ReplaceOrInsert(item); old != nil && r.changeLog != nil && !r.isTombstone(old.(RangeItem)) {
+		r.recordChange(ChangeDelete, old.(RangeItem))
+	}
+	r.recordChange(ChangeInsert, item)
+	if len(r.tombstones) > 0 {
+		delete(r.tombstones, string(item.GetStartKey()))
+	}
+}
+
+// delete deletes the item with the same start key as the given item from the btree and returns it.
+func (r *RangeTree) delete(item RangeItem) RangeItem {
+	ret := r.tree.Delete(item)
+	if ret == nil {
+		return nil
+	}
+	r.recordChange(ChangeDelete, ret.(RangeItem))
+	return ret.(RangeItem)
+}
+
+// get returns the live item with the same start key as the given item, or nil if there is no such item.
+func (r *RangeTree) get(item RangeItem) RangeItem {
+	ret := r.tree.Get(item)
+	if ret == nil || r.isTombstone(ret.(RangeItem)) {
+		return nil
+	}
+	return ret.(RangeItem)
+}
+
+func (r *RangeTree) isTombstone(item RangeItem) bool {
+	if len(r.tombstones) == 0 {
+		return false
+	}
+	_, ok := r.tombstones[string(item.GetStartKey())]
+	return ok
+}
+
+// liveIterator wraps f as a btree iterator which skips the tombstones.
+func (r *RangeTree) liveIterator(f func(item RangeItem) bool) btree.ItemIterator {
+	return func(i btree.Item) bool {
+		item := i.(RangeItem)
+		if r.isTombstone(item) {
+			return true
+		}
+		return f(item)
+	}
+}
+
+func (r *RangeTree) ascend(f func(item RangeItem) bool) {
+	r.tree.Ascend(r.liveIterator(f))
+}
+
+func (r *RangeTree) descend(f func(item RangeItem) bool) {
+	r.tree.Descend(r.liveIterator(f))
+}
+
+func (r *RangeTree) ascendGreaterOrEqual(pivot btree.Item, f func(item RangeItem) bool) {
+	r.tree.AscendGreaterOrEqual(pivot, r.liveIterator(f))
+}
+
+func (r *RangeTree) descendLessOrEqual(pivot btree.Item, f func(item RangeItem) bool) {
+	r.tree.DescendLessOrEqual(pivot, r.liveIterator(f))
+}
@@ -16,6 +16,10 @@ package rangetree
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -49,6 +53,19 @@ func (s *simpleBucketItem) GetEndKey() []byte {
 	return s.endKey
 }
 
+// payloadItem is a simpleBucketItem with a payload.
+type payloadItem struct {
+	simpleBucketItem
+	payload string
+}
+
+func newPayloadItem(startKey, endKey []byte, payload string) *payloadItem {
+	return &payloadItem{
+		simpleBucketItem: simpleBucketItem{startKey: startKey, endKey: endKey},
+		payload:          payload,
+	}
+}
+
 func minKey(a, b []byte) []byte {
 	if bytes.Compare(a, b) < 0 {
 		return a
@@ -85,6 +102,17 @@ func bucketDebrisFactory(startKey, endKey []byte, item RangeItem) []RangeItem {
 	return res
 }
 
+// payloadDebrisFactory is a bucketDebrisFactory keeping the payload of the payloadItem.
+func payloadDebrisFactory(startKey, endKey []byte, item RangeItem) []RangeItem {
+	res := bucketDebrisFactory(startKey, endKey, item)
+	if p, ok := item.(*payloadItem); ok {
+		for i, debris := range res {
+			res[i] = newPayloadItem(debris.GetStartKey(), debris.GetEndKey(), p.payload)
+		}
+	}
+	return res
+}
+
 func TestRingPutItem(t *testing.T) {
 	t.Parallel()
 	re := require.New(t)
@@ -138,3 +166,2478 @@ func TestDebris(t *testing.T) {
 	overlaps = bucketDebrisFactory([]byte("100"), []byte("200"), ringItem)
 	re.Empty(overlaps)
 }
+
+func TestOverlapBoundaries(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("100")))
+	bucketTree.Update(newSimpleBucketItem([]byte("100"), []byte("200")))
+	bucketTree.Update(newSimpleBucketItem([]byte("200"), []byte("300")))
+	bucketTree.Update(newSimpleBucketItem([]byte("300"), []byte("")))
+
+	// enclosing: [010,100] straddles both boundaries of [020,090].
+	left, right, inside := bucketTree.OverlapBoundaries(newSimpleBucketItem([]byte("020"), []byte("090")))
+	re.Equal([]byte("010"), left.GetStartKey())
+	re.Equal(left, right)
+	re.Empty(inside)
+
+	// separate straddlers: [010,100] on the left, [200,300] on the right.
+	left, right, inside = bucketTree.OverlapBoundaries(newSimpleBucketItem([]byte("050"), []byte("250")))
+	re.Equal([]byte("010"), left.GetStartKey())
+	re.Equal([]byte("200"), right.GetStartKey())
+	re.Len(inside, 1)
+	re.Equal([]byte("100"), inside[0].GetStartKey())
+
+	// no straddlers: the boundaries are aligned with the items.
+	left, right, inside = bucketTree.OverlapBoundaries(newSimpleBucketItem([]byte("100"), []byte("300")))
+	re.Nil(left)
+	re.Nil(right)
+	re.Len(inside, 2)
+
+	// the unbounded item straddles the end key, but an unbounded query has no right straddler.
+	_, right, _ = bucketTree.OverlapBoundaries(newSimpleBucketItem([]byte("250"), []byte("400")))
+	re.Equal([]byte("300"), right.GetStartKey())
+	left, right, inside = bucketTree.OverlapBoundaries(newSimpleBucketItem([]byte("250"), []byte("")))
+	re.Equal([]byte("200"), left.GetStartKey())
+	re.Nil(right)
+	re.Len(inside, 1)
+}
+
+func TestUpdateWithDebris(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	bucketTree.Update(newSimpleBucketItem([]byte("000"), []byte("100")))
+	bucketTree.Update(newSimpleBucketItem([]byte("100"), []byte("200")))
+
+	// left-clip: [000,100] keeps [000,050].
+	overlaps, debris := bucketTree.UpdateWithDebris(newSimpleBucketItem([]byte("050"), []byte("100")))
+	re.Len(overlaps, 1)
+	re.Len(debris, 1)
+	re.Equal([]byte("000"), debris[0].GetStartKey())
+	re.Equal([]byte("050"), debris[0].GetEndKey())
+
+	// right-clip: [100,200] keeps [150,200].
+	overlaps, debris = bucketTree.UpdateWithDebris(newSimpleBucketItem([]byte("100"), []byte("150")))
+	re.Len(overlaps, 1)
+	re.Len(debris, 1)
+	re.Equal([]byte("150"), debris[0].GetStartKey())
+	re.Equal([]byte("200"), debris[0].GetEndKey())
+
+	// both-sides: [150,200] keeps [150,160] and [170,200].
+	overlaps, debris = bucketTree.UpdateWithDebris(newSimpleBucketItem([]byte("160"), []byte("170")))
+	re.Len(overlaps, 1)
+	re.Len(debris, 2)
+	re.Equal([]byte("150"), debris[0].GetStartKey())
+	re.Equal([]byte("160"), debris[0].GetEndKey())
+	re.Equal([]byte("170"), debris[1].GetStartKey())
+	re.Equal([]byte("200"), debris[1].GetEndKey())
+	re.Equal(6, bucketTree.Len())
+
+	// fully enclosed overlaps leave no debris.
+	overlaps, debris = bucketTree.UpdateWithDebris(newSimpleBucketItem([]byte("000"), []byte("200")))
+	re.Len(overlaps, 6)
+	re.Empty(debris)
+	re.Equal(1, bucketTree.Len())
+}
+
+func TestNewRangeTreeWithCapacity(t *testing.T) {
+	// AllocsPerRun can not be called in a parallel test.
+	re := require.New(t)
+	bucketTree := NewRangeTreeWithCapacity(2, bucketDebrisFactory, 1000)
+	bucketTree.Update(newSimpleBucketItem([]byte("002"), []byte("100")))
+	bucketTree.Update(newSimpleBucketItem([]byte("100"), []byte("200")))
+	re.Equal(2, bucketTree.Len())
+	re.Len(bucketTree.GetOverlaps(newSimpleBucketItem([]byte("010"), []byte("110"))), 2)
+
+	// the preallocated nodes save the allocations of loading the expected items.
+	items := make([]RangeItem, 1000)
+	for i := range items {
+		items[i] = newSimpleBucketItem([]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprintf("%04d", i+1)))
+	}
+	load := func(newTree func() *RangeTree) float64 {
+		return testing.AllocsPerRun(5, func() {
+			tree := newTree()
+			for _, item := range items {
+				tree.Update(item)
+			}
+		})
+	}
+	withCapacity := load(func() *RangeTree { return NewRangeTreeWithCapacity(32, bucketDebrisFactory, len(items)) })
+	withoutCapacity := load(func() *RangeTree { return NewRangeTree(32, bucketDebrisFactory) })
+	re.Less(withCapacity, withoutCapacity-float64(len(items)/32))
+
+	// the bad degrees panic like btree.New instead of dividing by zero.
+	re.PanicsWithValue("bad degree", func() { NewRangeTreeWithCapacity(1, bucketDebrisFactory, 1000) })
+	re.PanicsWithValue("bad degree", func() { NewRangeTreeWithCapacity(0, bucketDebrisFactory, 1000) })
+}
+
+const benchmarkBulkLoadSize = 100000
+
+func runBulkLoadBenchmark(b *testing.B, newTree func() *RangeTree) {
+	items := make([]*simpleBucketItem, benchmarkBulkLoadSize)
+	for i := range items {
+		items[i] = newSimpleBucketItem([]byte(fmt.Sprintf("%08d", i)), []byte(fmt.Sprintf("%08d", i+1)))
+	}
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tree := newTree()
+		for _, item := range items {
+			tree.Update(item)
+		}
+	}
+}
+
+func BenchmarkBulkLoad(b *testing.B) {
+	runBulkLoadBenchmark(b, func() *RangeTree {
+		return NewRangeTree(32, bucketDebrisFactory)
+	})
+}
+
+func BenchmarkBulkLoadWithCapacity(b *testing.B) {
+	runBulkLoadBenchmark(b, func() *RangeTree {
+		return NewRangeTreeWithCapacity(32, bucketDebrisFactory, benchmarkBulkLoadSize)
+	})
+}
+
+func TestDominantOverlap(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Nil(bucketTree.DominantOverlap(newSimpleBucketItem([]byte("000"), []byte("100"))))
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	bucketTree.Update(newSimpleBucketItem([]byte("020"), []byte("060")))
+	bucketTree.Update(newSimpleBucketItem([]byte("060"), []byte("100")))
+	bucketTree.Update(newSimpleBucketItem([]byte("100"), []byte("")))
+
+	re.Equal([]byte("020"), bucketTree.DominantOverlap(newSimpleBucketItem([]byte("015"), []byte("070"))).GetStartKey())
+	re.Nil(bucketTree.DominantOverlap(newSimpleBucketItem([]byte("000"), []byte("010"))))
+	// ties return the first overlap: [020,060] and [060,100] both have 30 keys in [030,090].
+	re.Equal([]byte("020"), bucketTree.DominantOverlap(newSimpleBucketItem([]byte("030"), []byte("090"))).GetStartKey())
+	// the unbounded item covering the query tail wins.
+	re.Equal([]byte("100"), bucketTree.DominantOverlap(newSimpleBucketItem([]byte("000"), []byte(""))).GetStartKey())
+	// but it is compared by the bounded intersection if the query is bounded.
+	re.Equal([]byte("060"), bucketTree.DominantOverlap(newSimpleBucketItem([]byte("050"), []byte("110"))).GetStartKey())
+	re.Equal([]byte("100"), bucketTree.DominantOverlap(newSimpleBucketItem([]byte("090"), []byte("200"))).GetStartKey())
+
+	// the intersections are compared with the keys padded to the same length.
+	mixed := NewRangeTree(2, bucketDebrisFactory)
+	mixed.Update(newSimpleBucketItem([]byte("a"), []byte("b")))
+	mixed.Update(newSimpleBucketItem([]byte("b"), []byte("b\x00\x05")))
+	re.Equal([]byte("a"), mixed.DominantOverlap(newSimpleBucketItem([]byte("a"), []byte("c"))).GetStartKey())
+}
+
+func TestOverlapCoverage(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Empty(bucketTree.OverlapCoverage(newSimpleBucketItem([]byte{0}, []byte{100})))
+	bucketTree.Update(newSimpleBucketItem([]byte{10}, []byte{20}))
+	bucketTree.Update(newSimpleBucketItem([]byte{20}, []byte{60}))
+	bucketTree.Update(newSimpleBucketItem([]byte{60}, []byte{100}))
+	bucketTree.Update(newSimpleBucketItem([]byte{100}, []byte("")))
+
+	coverage := func(start, end []byte) map[byte]float64 {
+		res := make(map[byte]float64)
+		for _, c := range bucketTree.OverlapCoverage(newSimpleBucketItem(start, end)) {
+			res[c.Item.GetStartKey()[0]] = c.Fraction
+		}
+		return res
+	}
+	// [010,020] is fully inside, [020,060] is partially inside and [060,100] is untouched.
+	re.Equal(map[byte]float64{10: 1, 20: 0.25}, coverage([]byte{5}, []byte{30}))
+	re.Equal(map[byte]float64{10: 0.5}, coverage([]byte{15}, []byte{20}))
+	re.Equal(map[byte]float64{20: 0.5, 60: 0.5}, coverage([]byte{40}, []byte{80}))
+	// the unbounded item reports 0 even if it is queried entirely.
+	re.Equal(map[byte]float64{60: 1, 100: 0}, coverage([]byte{60}, []byte("")))
+	// the keys of different lengths are compared as the same length.
+	re.Equal(map[byte]float64{60: 0.5}, coverage([]byte{60}, []byte{80, 0}))
+}
+
+func TestOverlapLength(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Zero(bucketTree.OverlapLength(newSimpleBucketItem([]byte{0}, []byte(""))).Sign())
+	bucketTree.Update(newSimpleBucketItem([]byte{10}, []byte{20}))
+	bucketTree.Update(newSimpleBucketItem([]byte{30}, []byte{50}))
+	bucketTree.Update(newSimpleBucketItem([]byte{60}, []byte{70}))
+	bucketTree.Update(newSimpleBucketItem([]byte{100}, []byte("")))
+
+	// the gaps [020,030] and [050,060] are not counted.
+	re.Equal(int64(40), bucketTree.OverlapLength(newSimpleBucketItem([]byte{0}, []byte{80})).Int64())
+	re.Equal(int64(30), bucketTree.OverlapLength(newSimpleBucketItem([]byte{15}, []byte{65})).Int64())
+	re.Equal(int64(10), bucketTree.OverlapLength(newSimpleBucketItem([]byte{35}, []byte{45})).Int64())
+	re.Zero(bucketTree.OverlapLength(newSimpleBucketItem([]byte{20}, []byte{30})).Sign())
+	// the bounded query is only covered by the bounded part of the unbounded item.
+	re.Equal(int64(15), bucketTree.OverlapLength(newSimpleBucketItem([]byte{65}, []byte{110})).Int64())
+	re.Nil(bucketTree.OverlapLength(newSimpleBucketItem([]byte{65}, []byte(""))))
+	// the keys of different lengths are compared as the same length.
+	re.Equal(int64(20*256+1), bucketTree.OverlapLength(newSimpleBucketItem([]byte{40}, []byte{100, 1})).Int64())
+	re.Equal(int64(5*256), bucketTree.OverlapLength(newSimpleBucketItem([]byte{65}, []byte{100, 0})).Int64())
+	// the start key of the overlap is longer than the keys of the query.
+	mixed := NewRangeTree(2, bucketDebrisFactory)
+	mixed.Update(newSimpleBucketItem([]byte("a\x00\x05"), []byte("b")))
+	re.Equal(int64(0x010000-5), mixed.OverlapLength(newSimpleBucketItem([]byte("a"), []byte("c"))).Int64())
+}
+
+func TestUnboundedCount(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Zero(bucketTree.UnboundedCount())
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("100")))
+	re.Zero(bucketTree.UnboundedCount())
+	bucketTree.Update(newSimpleBucketItem([]byte("100"), []byte("")))
+	re.Equal(1, bucketTree.UnboundedCount())
+	// a tree with two unbounded items is malformed, it can only be built by bypassing Update.
+	bucketTree.tree.ReplaceOrInsert(newSimpleBucketItem([]byte("200"), []byte("")))
+	re.Equal(2, bucketTree.UnboundedCount())
+}
+
+func TestGetOverlapsBatch(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Equal([][]RangeItem{nil}, bucketTree.GetOverlapsBatch([]RangeItem{newSimpleBucketItem([]byte("000"), []byte("100"))}))
+	for i := 0; i < 200; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%04d", i*20)), []byte(fmt.Sprintf("%04d", i*20+10))))
+	}
+	bucketTree.Update(newSimpleBucketItem([]byte("5000"), []byte("")))
+
+	queries := []RangeItem{
+		newSimpleBucketItem([]byte("3000"), []byte("3100")),
+		newSimpleBucketItem([]byte("0005"), []byte("0025")),
+		// overlapping queries
+		newSimpleBucketItem([]byte("0000"), []byte("2000")),
+		newSimpleBucketItem([]byte("1995"), []byte("2035")),
+		// queries in the gaps
+		newSimpleBucketItem([]byte("0011"), []byte("0019")),
+		newSimpleBucketItem([]byte("4500"), []byte("4600")),
+		newSimpleBucketItem([]byte("0011"), []byte("0011")),
+		// unbounded queries
+		newSimpleBucketItem([]byte("3950"), []byte("")),
+		newSimpleBucketItem([]byte(""), []byte("")),
+		newSimpleBucketItem([]byte("0005"), []byte("0025")),
+	}
+	results := bucketTree.GetOverlapsBatch(queries)
+	re.Len(results, len(queries))
+	for i, query := range queries {
+		re.Equal(bucketTree.GetOverlaps(query), results[i])
+	}
+	re.Len(results[0], 5)
+	re.Len(results[2], 100)
+	re.Empty(results[4])
+	re.Len(results[7], 3)
+	re.Len(results[8], 201)
+}
+
+func TestTouchingNeighbors(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	bucketTree.Update(newSimpleBucketItem([]byte("020"), []byte("030")))
+	bucketTree.Update(newSimpleBucketItem([]byte("035"), []byte("040")))
+	bucketTree.Update(newSimpleBucketItem([]byte("040"), []byte("")))
+	check := func(start, end string, expectedLeft, expectedRight string) {
+		left, right := bucketTree.TouchingNeighbors(newSimpleBucketItem([]byte(start), []byte(end)))
+		if expectedLeft == "" {
+			re.Nil(left)
+		} else {
+			re.Equal([]byte(expectedLeft), left.GetStartKey())
+		}
+		if expectedRight == "" {
+			re.Nil(right)
+		} else {
+			re.Equal([]byte(expectedRight), right.GetStartKey())
+		}
+	}
+	// the touching neighbors.
+	check("010", "020", "", "020")
+	check("035", "040", "", "040")
+	check("040", "", "035", "")
+	// the gapped neighbors.
+	check("020", "030", "010", "")
+	check("031", "034", "", "")
+	// the item not in the tree touching both sides.
+	check("030", "035", "020", "035")
+	// the overlapping neighbors.
+	check("015", "037", "", "")
+	check("025", "035", "", "035")
+}
+
+func TestDeferredDelete(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	for i := 0; i < 10; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+	}
+	re.Nil(bucketTree.GetAt(-1))
+	re.Nil(bucketTree.GetAt(10))
+	bucketTree.SetDeferredDelete(true)
+	re.NotNil(bucketTree.Remove(newSimpleBucketItem([]byte("020"), []byte("030"))))
+	re.NotNil(bucketTree.Remove(newSimpleBucketItem([]byte("040"), []byte("050"))))
+	re.Nil(bucketTree.Remove(newSimpleBucketItem([]byte("040"), []byte("050"))))
+	re.Nil(bucketTree.Remove(newSimpleBucketItem([]byte("045"), []byte("050"))))
+	re.Equal(8, bucketTree.Len())
+	// the tombstones stay in the btree.
+	re.Equal(10, bucketTree.tree.Len())
+
+	// the queries skip the tombstones.
+	re.Nil(bucketTree.Find(newSimpleBucketItem([]byte("025"), nil)))
+	re.Len(bucketTree.GetOverlaps(newSimpleBucketItem([]byte("015"), []byte("055"))), 3)
+	re.Empty(bucketTree.GetOverlaps(newSimpleBucketItem([]byte("040"), []byte("050"))))
+	prev, next := bucketTree.GetAdjacentItem(newSimpleBucketItem([]byte("030"), []byte("040")))
+	re.Equal([]byte("010"), prev.GetStartKey())
+	re.Equal([]byte("050"), next.GetStartKey())
+	re.Equal([]byte("050"), bucketTree.GetAt(3).GetStartKey())
+	re.Nil(bucketTree.GetAt(-1))
+	re.Nil(bucketTree.GetAt(8))
+	item, index := bucketTree.GetWithIndex(newSimpleBucketItem([]byte("050"), nil))
+	re.Equal([]byte("050"), item.GetStartKey())
+	re.Equal(3, index)
+	item, index = bucketTree.GetWithIndex(newSimpleBucketItem([]byte("040"), nil))
+	re.Nil(item)
+	re.Equal(3, index)
+	var scanned int
+	bucketTree.ScanRange(newSimpleBucketItem([]byte("000"), nil), func(_ RangeItem) bool {
+		scanned++
+		return true
+	})
+	re.Equal(8, scanned)
+
+	// a new item covering a tombstone is found by the queries.
+	bucketTree.Update(newSimpleBucketItem([]byte("015"), []byte("035")))
+	re.Equal([]byte("015"), bucketTree.Find(newSimpleBucketItem([]byte("025"), nil)).GetStartKey())
+	re.Len(bucketTree.GetOverlaps(newSimpleBucketItem([]byte("015"), []byte("055"))), 3)
+	// a new item replacing a tombstone resurrects its start key.
+	bucketTree.Update(newSimpleBucketItem([]byte("040"), []byte("045")))
+	re.Equal([]byte("040"), bucketTree.Find(newSimpleBucketItem([]byte("041"), nil)).GetStartKey())
+	re.Equal(10, bucketTree.Len())
+
+	// compact reclaims the tombstones.
+	bucketTree.Compact()
+	re.Equal(10, bucketTree.Len())
+	re.Equal(10, bucketTree.tree.Len())
+	re.Nil(bucketTree.Find(newSimpleBucketItem([]byte("047"), nil)))
+
+	// turning off the deferred mode compacts the tree.
+	re.NotNil(bucketTree.Remove(newSimpleBucketItem([]byte("000"), nil)))
+	re.Equal(10, bucketTree.tree.Len())
+	bucketTree.SetDeferredDelete(false)
+	re.Equal(9, bucketTree.Len())
+	re.Equal(9, bucketTree.tree.Len())
+	re.NotNil(bucketTree.Remove(newSimpleBucketItem([]byte("040"), nil)))
+	re.Equal(8, bucketTree.tree.Len())
+}
+
+func TestFindStrict(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Nil(bucketTree.FindStrict([]byte("")))
+	// key range: [010,020], [020,030], [040,050], [060,]
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	bucketTree.Update(newSimpleBucketItem([]byte("020"), []byte("030")))
+	bucketTree.Update(newSimpleBucketItem([]byte("040"), []byte("050")))
+	bucketTree.Update(newSimpleBucketItem([]byte("060"), []byte("")))
+	for _, c := range []struct {
+		key      string
+		expected string
+	}{
+		// the key inside an item.
+		{"015", "010"},
+		{"025", "020"},
+		{"100", "060"},
+		// the key equal to the start key of an item.
+		{"010", "010"},
+		{"040", "040"},
+		{"060", "060"},
+		// the key equal to the end key of an item and the start key of the next item.
+		{"020", "020"},
+		// the key equal to the end key of an item followed by a gap.
+		{"030", ""},
+		{"050", ""},
+		// the key in a gap or before all items.
+		{"035", ""},
+		{"055", ""},
+		{"005", ""},
+		{"", ""},
+	} {
+		item := bucketTree.FindStrict([]byte(c.key))
+		if c.expected == "" {
+			re.Nil(item, c.key)
+		} else {
+			re.Equal([]byte(c.expected), item.GetStartKey(), c.key)
+		}
+		// Find has the same boundaries.
+		re.Equal(item, bucketTree.Find(newSimpleBucketItem([]byte(c.key), nil)), c.key)
+	}
+
+	// the point markers contain no key.
+	bucketTree.SetAllowPoints(true)
+	bucketTree.Update(newSimpleBucketItem([]byte("035"), []byte("035")))
+	re.Nil(bucketTree.FindStrict([]byte("035")))
+	re.NotNil(bucketTree.Find(newSimpleBucketItem([]byte("035"), nil)))
+}
+
+func TestHasStartKey(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	// keepFactory keeps the overlaps as they are, which is a buggy factory.
+	keepFactory := func(_, _ []byte, item RangeItem) []RangeItem {
+		return []RangeItem{item}
+	}
+	bucketTree := NewRangeTree(2, keepFactory)
+	re.False(bucketTree.HasStartKey([]byte("010")))
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("050")))
+	re.True(bucketTree.HasStartKey([]byte("010")))
+	re.False(bucketTree.HasStartKey([]byte("020")))
+
+	// the item with the same start key is replaced silently, [020,050] is lost.
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	re.Equal(1, bucketTree.Len())
+	re.Nil(bucketTree.Find(newSimpleBucketItem([]byte("030"), nil)))
+
+	bucketTree.SetDeferredDelete(true)
+	bucketTree.Remove(newSimpleBucketItem([]byte("010"), nil))
+	re.False(bucketTree.HasStartKey([]byte("010")))
+}
+
+func TestForEachFromLast(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	tree := newGapTestTree("10", "20", "30", "40", "50", "60", "70", "80")
+	collect := func(n int, limit int) []string {
+		var keys []string
+		tree.ForEachFromLast(n, func(item RangeItem) bool {
+			keys = append(keys, string(item.GetStartKey()))
+			return len(keys) != limit
+		})
+		return keys
+	}
+	re.Equal([]string{"70", "50"}, collect(2, -1))
+	re.Equal([]string{"70", "50", "30", "10"}, collect(4, -1))
+	re.Equal([]string{"70", "50", "30", "10"}, collect(10, -1))
+	re.Empty(collect(0, -1))
+	re.Empty(collect(-1, -1))
+	// f stops the iteration before the limit.
+	re.Equal([]string{"70"}, collect(3, 1))
+}
+
+func TestWalkBackwardFrom(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	// key range: [010,020], [020,030], [040,050], [060,]
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	bucketTree.Update(newSimpleBucketItem([]byte("020"), []byte("030")))
+	bucketTree.Update(newSimpleBucketItem([]byte("040"), []byte("050")))
+	bucketTree.Update(newSimpleBucketItem([]byte("060"), []byte("")))
+	walk := func(key string, limit int) []string {
+		var res []string
+		bucketTree.WalkBackwardFrom([]byte(key), func(item RangeItem) bool {
+			res = append(res, string(item.GetStartKey()))
+			return len(res) < limit
+		})
+		return res
+	}
+	// the key inside an item.
+	re.Equal([]string{"040", "020", "010"}, walk("045", 10))
+	re.Equal([]string{"020", "010"}, walk("020", 10))
+	re.Equal([]string{"060", "040", "020", "010"}, walk("100", 10))
+	// the key in a gap.
+	re.Equal([]string{"020", "010"}, walk("035", 10))
+	re.Equal([]string{"040", "020", "010"}, walk("050", 10))
+	// the key before all items.
+	re.Empty(walk("005", 10))
+	re.Empty(walk("", 10))
+	// stop early.
+	re.Equal([]string{"060", "040"}, walk("060", 2))
+}
+
+func TestAscendDescendRange(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	for i := 0; i < 10; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+	}
+	collect := func(iterate func(start, end RangeItem, f func(item RangeItem) bool), start, end string) []string {
+		var keys []string
+		iterate(newSimpleBucketItem([]byte(start), nil), newSimpleBucketItem([]byte(end), nil), func(item RangeItem) bool {
+			keys = append(keys, string(item.GetStartKey()))
+			return true
+		})
+		return keys
+	}
+	// [start, end) in ascending order.
+	re.Equal([]string{"020", "030", "040"}, collect(bucketTree.AscendRange, "020", "050"))
+	re.Equal([]string{"030", "040"}, collect(bucketTree.AscendRange, "025", "045"))
+	re.Empty(collect(bucketTree.AscendRange, "050", "050"))
+	re.Empty(collect(bucketTree.AscendRange, "050", "020"))
+	// (end, start] in descending order.
+	re.Equal([]string{"050", "040", "030"}, collect(bucketTree.DescendRange, "050", "020"))
+	re.Equal([]string{"040", "030"}, collect(bucketTree.DescendRange, "045", "025"))
+	re.Empty(collect(bucketTree.DescendRange, "050", "050"))
+	re.Empty(collect(bucketTree.DescendRange, "020", "050"))
+
+	// stop when f returns false.
+	var count int
+	bucketTree.AscendRange(newSimpleBucketItem([]byte("000"), nil), newSimpleBucketItem([]byte("100"), nil), func(_ RangeItem) bool {
+		count++
+		return count < 3
+	})
+	re.Equal(3, count)
+}
+
+func TestBounds(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	_, _, ok := bucketTree.Bounds()
+	re.False(ok)
+
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	startKey, endKey, ok := bucketTree.Bounds()
+	re.True(ok)
+	re.Equal([]byte("010"), startKey)
+	re.Equal([]byte("020"), endKey)
+
+	bucketTree.Update(newSimpleBucketItem([]byte("050"), []byte("080")))
+	startKey, endKey, ok = bucketTree.Bounds()
+	re.True(ok)
+	re.Equal([]byte("010"), startKey)
+	re.Equal([]byte("080"), endKey)
+
+	// the unbounded tail is returned as an empty end key.
+	bucketTree.Update(newSimpleBucketItem([]byte("100"), []byte("")))
+	startKey, endKey, ok = bucketTree.Bounds()
+	re.True(ok)
+	re.Equal([]byte("010"), startKey)
+	re.Empty(endKey)
+}
+
+func TestCount(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	isHot := func(item RangeItem) bool {
+		return item.(*payloadItem).payload == "hot"
+	}
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Zero(bucketTree.Count(isHot))
+	for i := 0; i < 10; i++ {
+		payload := "cold"
+		if i%3 == 0 {
+			payload = "hot"
+		}
+		bucketTree.Update(newPayloadItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10)), payload))
+	}
+	var hot []RangeItem
+	bucketTree.ScanWhere(newSimpleBucketItem([]byte(""), nil), isHot, func(item RangeItem) bool {
+		hot = append(hot, item)
+		return true
+	})
+	re.Equal(4, bucketTree.Count(isHot))
+	re.Len(hot, bucketTree.Count(isHot))
+	re.Equal(10, bucketTree.Count(func(RangeItem) bool { return true }))
+}
+
+func TestScanWhere(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	for i := 0; i < 10; i++ {
+		payload := "cold"
+		if i%3 == 0 {
+			payload = "hot"
+		}
+		bucketTree.Update(newPayloadItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10)), payload))
+	}
+	isHot := func(item RangeItem) bool {
+		return item.(*payloadItem).payload == "hot"
+	}
+	var keys []string
+	bucketTree.ScanWhere(newSimpleBucketItem([]byte("005"), nil), isHot, func(item RangeItem) bool {
+		keys = append(keys, string(item.GetStartKey()))
+		return true
+	})
+	re.Equal([]string{"000", "030", "060", "090"}, keys)
+
+	// f returning false still stops the scan.
+	keys = keys[:0]
+	bucketTree.ScanWhere(newSimpleBucketItem([]byte("010"), nil), isHot, func(item RangeItem) bool {
+		keys = append(keys, string(item.GetStartKey()))
+		return len(keys) < 2
+	})
+	re.Equal([]string{"030", "060"}, keys)
+}
+
+func TestIntersects(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.False(bucketTree.Intersects([]byte(""), []byte("")))
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	bucketTree.Update(newSimpleBucketItem([]byte("050"), []byte("060")))
+
+	// empty windows
+	re.False(bucketTree.Intersects([]byte("015"), []byte("015")))
+	re.False(bucketTree.Intersects([]byte("060"), []byte("050")))
+	// windows hitting one item
+	re.True(bucketTree.Intersects([]byte("015"), []byte("030")))
+	re.True(bucketTree.Intersects([]byte("030"), []byte("051")))
+	re.True(bucketTree.Intersects([]byte("000"), []byte("")))
+	re.True(bucketTree.Intersects([]byte("055"), []byte("")))
+	// windows in the gaps
+	re.False(bucketTree.Intersects([]byte("020"), []byte("050")))
+	re.False(bucketTree.Intersects([]byte("000"), []byte("010")))
+	re.False(bucketTree.Intersects([]byte("060"), []byte("")))
+}
+
+func TestContainedBy(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Empty(bucketTree.ContainedBy([]byte(""), []byte("")))
+	for i := 0; i < 5; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+	}
+	bucketTree.Update(newSimpleBucketItem([]byte("050"), []byte("")))
+	startKeys := func(items []RangeItem) []string {
+		var keys []string
+		for _, item := range items {
+			keys = append(keys, string(item.GetStartKey()))
+		}
+		return keys
+	}
+
+	// the straddling items [000,010] and [030,040] are excluded.
+	re.Equal([]string{"010", "020"}, startKeys(bucketTree.ContainedBy([]byte("005"), []byte("035"))))
+	// the aligned boundaries.
+	re.Equal([]string{"010", "020", "030"}, startKeys(bucketTree.ContainedBy([]byte("010"), []byte("040"))))
+	re.Empty(bucketTree.ContainedBy([]byte("012"), []byte("018")))
+	// the unbounded item is only contained by the unbounded window.
+	re.Equal([]string{"040"}, startKeys(bucketTree.ContainedBy([]byte("040"), []byte("100"))))
+	re.Equal([]string{"040", "050"}, startKeys(bucketTree.ContainedBy([]byte("040"), []byte(""))))
+	re.Len(bucketTree.ContainedBy([]byte(""), []byte("")), 6)
+}
+
+func TestBoundedLen(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	tree := NewRangeTree(2, bucketDebrisFactory)
+	re.Zero(tree.BoundedLen(nil, nil))
+	tree.Update(newSimpleBucketItem([]byte("010"), []byte("030")))
+	tree.Update(newSimpleBucketItem([]byte("030"), []byte("040")))
+	tree.Update(newSimpleBucketItem([]byte("050"), []byte("")))
+
+	re.Equal(3, tree.BoundedLen(nil, nil))
+	re.Equal(len(tree.GetOverlapsInRange([]byte("020"), []byte("050"))), tree.BoundedLen([]byte("020"), []byte("050")))
+	// the item straddling the start key is counted, but it does not start in the window.
+	re.Equal(2, tree.BoundedLen([]byte("020"), []byte("035")))
+	startsInWindow := 0
+	tree.AscendRange(KeyRange{StartKey: []byte("020")}, KeyRange{StartKey: []byte("035")}, func(RangeItem) bool {
+		startsInWindow++
+		return true
+	})
+	re.Equal(1, startsInWindow)
+	re.Equal(1, tree.BoundedLen([]byte("040"), []byte("060")))
+	re.Zero(tree.BoundedLen([]byte("040"), []byte("050")))
+	re.Equal(1, tree.BoundedLen([]byte("090"), nil))
+}
+
+func TestGetOverlapsInclusive(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	bucketTree.Update(newSimpleBucketItem([]byte{0x10}, []byte{0x20}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x20}, []byte{0x30}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0xff}, []byte{}))
+
+	// the item starting at the end key is only included by the inclusive query.
+	re.Len(bucketTree.GetOverlapsInRange([]byte{0x00}, []byte{0x20}), 1)
+	re.Len(bucketTree.GetOverlapsInclusive([]byte{0x00}, []byte{0x20}), 2)
+	re.Len(bucketTree.GetOverlapsInRange([]byte{0x00}, []byte{0x1f}), 1)
+	re.Len(bucketTree.GetOverlapsInclusive([]byte{0x00}, []byte{0x1f}), 1)
+	// the end key of all 0xFF bytes means unbounded.
+	re.Len(bucketTree.GetOverlapsInRange([]byte{0x15}, []byte{0xff}), 2)
+	re.Len(bucketTree.GetOverlapsInclusive([]byte{0x15}, []byte{0xff}), 3)
+}
+
+func TestGetOverlapsReverse(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	// key range: [000,010], [010,020], [030,040], ..., [080,090], [100,]
+	for i := 0; i < 9; i++ {
+		if i != 2 {
+			bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+		}
+	}
+	bucketTree.Update(newSimpleBucketItem([]byte("100"), []byte("")))
+	reverse := func(item RangeItem) []RangeItem {
+		var res []RangeItem
+		bucketTree.GetOverlapsReverse(item, func(over RangeItem) bool {
+			res = append(res, over)
+			return true
+		})
+		return res
+	}
+	for _, keys := range [][2]string{
+		{"", ""}, {"005", "035"}, {"010", "030"}, {"015", "025"}, {"020", "030"},
+		{"022", "028"}, {"025", "040"}, {"035", "036"}, {"050", ""}, {"095", "099"},
+		{"095", ""}, {"105", "110"}, {"110", ""},
+	} {
+		item := newSimpleBucketItem([]byte(keys[0]), []byte(keys[1]))
+		expect := bucketTree.GetOverlaps(item)
+		for i, j := 0, len(expect)-1; i < j; i, j = i+1, j-1 {
+			expect[i], expect[j] = expect[j], expect[i]
+		}
+		re.Equal(expect, reverse(item), keys)
+	}
+
+	// stop when f returns false.
+	var res []RangeItem
+	bucketTree.GetOverlapsReverse(newSimpleBucketItem([]byte("015"), []byte("065")), func(over RangeItem) bool {
+		res = append(res, over)
+		return len(res) < 2
+	})
+	re.Len(res, 2)
+	re.Equal([]byte("060"), res[0].GetStartKey())
+	re.Equal([]byte("050"), res[1].GetStartKey())
+}
+
+func TestScanOverlapping(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	// key range: [000,010], [010,020], [030,040], ..., [080,090], [100,]
+	for i := 0; i < 9; i++ {
+		if i != 2 {
+			bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+		}
+	}
+	bucketTree.Update(newSimpleBucketItem([]byte("100"), []byte("")))
+	scan := func(item RangeItem) []RangeItem {
+		var res []RangeItem
+		bucketTree.ScanOverlapping(item, func(over RangeItem) bool {
+			res = append(res, over)
+			return true
+		})
+		return res
+	}
+	for _, keys := range [][2]string{
+		{"", ""}, {"005", "035"}, {"010", "030"}, {"015", "025"}, {"020", "030"},
+		{"022", "028"}, {"025", "040"}, {"035", "036"}, {"050", ""}, {"095", "099"},
+		{"095", ""}, {"105", "110"}, {"110", ""},
+	} {
+		item := newSimpleBucketItem([]byte(keys[0]), []byte(keys[1]))
+		re.Equal(bucketTree.GetOverlaps(item), scan(item), keys)
+	}
+	// the left straddler is included and the item starting at the end key is excluded.
+	overlaps := scan(newSimpleBucketItem([]byte("015"), []byte("040")))
+	re.Len(overlaps, 2)
+	re.Equal([]byte("010"), overlaps[0].GetStartKey())
+	re.Equal([]byte("030"), overlaps[1].GetStartKey())
+	re.Empty(scan(newSimpleBucketItem([]byte("020"), []byte("030"))))
+
+	// the point marker overlaps with the item starting at the same key.
+	bucketTree.SetAllowPoints(true)
+	point := newSimpleBucketItem([]byte("030"), []byte("030"))
+	re.Equal(bucketTree.GetOverlaps(point), scan(point))
+	re.Len(scan(point), 1)
+
+	// stop when f returns false.
+	var res []RangeItem
+	bucketTree.ScanOverlapping(newSimpleBucketItem([]byte("015"), []byte("065")), func(over RangeItem) bool {
+		res = append(res, over)
+		return len(res) < 2
+	})
+	re.Len(res, 2)
+	re.Equal([]byte("010"), res[0].GetStartKey())
+	re.Equal([]byte("030"), res[1].GetStartKey())
+}
+
+func TestGetOverlapsWithEmptyStartKey(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Nil(bucketTree.GetOverlaps(newSimpleBucketItem([]byte(""), []byte(""))))
+	for i := 1; i < 10; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+	}
+	// the empty start key is the minimum even if no item contains it.
+	re.Nil(bucketTree.Find(newSimpleBucketItem([]byte(""), nil)))
+	re.Empty(bucketTree.GetOverlaps(newSimpleBucketItem([]byte(""), []byte("010"))))
+	overlaps := bucketTree.GetOverlaps(newSimpleBucketItem([]byte(""), []byte("035")))
+	re.Len(overlaps, 3)
+	re.Equal([]byte("010"), overlaps[0].GetStartKey())
+	re.Len(bucketTree.GetOverlaps(newSimpleBucketItem([]byte(""), []byte(""))), 9)
+	re.Len(bucketTree.GetOverlaps(newSimpleBucketItem(nil, nil)), 9)
+
+	// the item starting with the empty key.
+	bucketTree.Update(newSimpleBucketItem([]byte(""), []byte("010")))
+	re.Equal([]byte("010"), bucketTree.Find(newSimpleBucketItem([]byte(""), nil)).GetEndKey())
+	overlaps = bucketTree.GetOverlaps(newSimpleBucketItem([]byte(""), []byte("015")))
+	re.Len(overlaps, 2)
+	re.Empty(overlaps[0].GetStartKey())
+	re.Len(bucketTree.GetOverlaps(newSimpleBucketItem([]byte(""), []byte(""))), 10)
+}
+
+func TestGetOverlapsN(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	for i := 0; i < 10; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+	}
+	item := newSimpleBucketItem([]byte("015"), []byte("075"))
+	re.Len(bucketTree.GetOverlaps(item), 7)
+	re.Empty(bucketTree.GetOverlapsN(item, 0))
+	re.Empty(bucketTree.GetOverlapsN(item, -1))
+	overlaps := bucketTree.GetOverlapsN(item, 3)
+	re.Len(overlaps, 3)
+	re.Equal([]byte("010"), overlaps[0].GetStartKey())
+	re.Equal([]byte("020"), overlaps[1].GetStartKey())
+	re.Equal([]byte("030"), overlaps[2].GetStartKey())
+	re.Len(bucketTree.GetOverlapsN(item, 7), 7)
+	re.Len(bucketTree.GetOverlapsN(item, 100), 7)
+
+	// the scan stops after collecting n items, the end key of the query is read twice per visited item.
+	query := &endCountingItem{simpleBucketItem: *item}
+	bucketTree.GetOverlaps(query)
+	re.Equal(16, query.endKeyCalls)
+	query.endKeyCalls = 0
+	bucketTree.GetOverlapsN(query, 3)
+	re.Equal(6, query.endKeyCalls)
+}
+
+// endCountingItem is a simpleBucketItem counting the calls of GetEndKey.
+type endCountingItem struct {
+	simpleBucketItem
+	endKeyCalls int
+}
+
+func (item *endCountingItem) GetEndKey() []byte {
+	item.endKeyCalls++
+	return item.simpleBucketItem.GetEndKey()
+}
+
+func TestExtendEnd(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	rekey := func(src RangeItem, newEnd []byte) RangeItem {
+		return newSimpleBucketItem(src.GetStartKey(), newEnd)
+	}
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	bucketTree.Update(newSimpleBucketItem([]byte("040"), []byte("050")))
+
+	// a clean extension.
+	version := bucketTree.Version()
+	re.True(bucketTree.ExtendEnd(newSimpleBucketItem([]byte("010"), nil), []byte("030"), rekey))
+	re.Equal([]byte("030"), bucketTree.Find(newSimpleBucketItem([]byte("025"), nil)).GetEndKey())
+	re.Greater(bucketTree.Version(), version)
+	// an extension up to exactly the next start.
+	re.True(bucketTree.ExtendEnd(newSimpleBucketItem([]byte("010"), nil), []byte("040"), rekey))
+	re.Equal([]byte("040"), bucketTree.Find(newSimpleBucketItem([]byte("035"), nil)).GetEndKey())
+	// an overlapping extension.
+	version = bucketTree.Version()
+	re.False(bucketTree.ExtendEnd(newSimpleBucketItem([]byte("010"), nil), []byte("045"), rekey))
+	re.False(bucketTree.ExtendEnd(newSimpleBucketItem([]byte("010"), nil), []byte(""), rekey))
+	// not an extension or not in the tree.
+	re.False(bucketTree.ExtendEnd(newSimpleBucketItem([]byte("010"), nil), []byte("030"), rekey))
+	re.False(bucketTree.ExtendEnd(newSimpleBucketItem([]byte("030"), nil), []byte("035"), rekey))
+	re.Equal(version, bucketTree.Version())
+	// the last item can be unbounded.
+	re.True(bucketTree.ExtendEnd(newSimpleBucketItem([]byte("040"), nil), []byte(""), rekey))
+	re.Equal(2, bucketTree.Len())
+	re.Equal(1, bucketTree.UnboundedCount())
+	re.False(bucketTree.ExtendEnd(newSimpleBucketItem([]byte("040"), nil), []byte("090"), rekey))
+}
+
+func TestMove(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	rekey := func(_ RangeItem, newStart, newEnd []byte) RangeItem {
+		return newSimpleBucketItem(newStart, newEnd)
+	}
+	keys := func(tree *RangeTree) []string {
+		var res []string
+		tree.ascend(func(item RangeItem) bool {
+			res = append(res, string(item.GetStartKey())+"-"+string(item.GetEndKey()))
+			return true
+		})
+		return res
+	}
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("030")))
+	bucketTree.Update(newSimpleBucketItem([]byte("050"), []byte("060")))
+	bucketTree.Update(newSimpleBucketItem([]byte("070"), []byte("")))
+	re.Nil(bucketTree.Move(newSimpleBucketItem([]byte("020"), nil), []byte("080"), rekey))
+
+	// move into the empty space.
+	re.Empty(bucketTree.Move(newSimpleBucketItem([]byte("010"), nil), []byte("030"), rekey))
+	re.Equal([]string{"030-050", "050-060", "070-"}, keys(bucketTree))
+	// move into the occupied space.
+	overlaps := bucketTree.Move(newSimpleBucketItem([]byte("050"), nil), []byte("045"), rekey)
+	re.Len(overlaps, 1)
+	re.Equal([]byte("030"), overlaps[0].GetStartKey())
+	re.Equal([]string{"030-045", "045-055", "070-"}, keys(bucketTree))
+	// move the unbounded item shifts only the start key.
+	re.Empty(bucketTree.Move(newSimpleBucketItem([]byte("070"), nil), []byte("060"), rekey))
+	re.Equal([]string{"030-045", "045-055", "060-"}, keys(bucketTree))
+	// the length is kept for the keys of different lengths.
+	re.Empty(bucketTree.Move(newSimpleBucketItem([]byte("030"), nil), []byte("01"), rekey))
+	moved := bucketTree.GetAt(0)
+	re.Equal([]byte("01"), moved.GetStartKey())
+	re.Equal([]byte{'0', '2', 0x05}, moved.GetEndKey())
+	re.Equal(keyDistance([]byte("030"), []byte("045"), 3).Int64(), keyDistance(moved.GetStartKey(), moved.GetEndKey(), 3).Int64())
+	// newStart is longer than the old keys.
+	shortTree := NewRangeTree(2, bucketDebrisFactory)
+	shortTree.Update(newSimpleBucketItem([]byte("a"), []byte("b")))
+	re.Empty(shortTree.Move(newSimpleBucketItem([]byte("a"), nil), []byte("c\x00\x10"), rekey))
+	re.Equal([]string{"c\x00\x10-d\x00\x10"}, keys(shortTree))
+}
+
+func TestShrinkTo(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	rekey := func(_ RangeItem, newStart, newEnd []byte) RangeItem {
+		return newSimpleBucketItem(newStart, newEnd)
+	}
+	type shrink struct {
+		start, end string
+		ok         bool
+	}
+	testCases := []struct {
+		start, end string
+		shrinks    []shrink
+		expect     []string
+	}{
+		// the left shrink.
+		{"010", "050", []shrink{{"020", "050", true}}, []string{"020-050"}},
+		// the right shrink.
+		{"010", "050", []shrink{{"010", "040", true}}, []string{"010-040"}},
+		// the both sides shrink.
+		{"010", "050", []shrink{{"020", "040", true}, {"025", "035", true}}, []string{"025-035"}},
+		{"010", "", []shrink{{"020", "", true}, {"020", "040", true}}, []string{"020-040"}},
+		// the invalid bounds.
+		{"010", "050", []shrink{
+			{"000", "040", false},
+			{"020", "060", false},
+			{"020", "", false},
+			{"030", "020", false},
+			{"030", "030", false},
+			{"010", "050", false},
+		}, []string{"010-050"}},
+		{"010", "", []shrink{{"000", "", false}, {"010", "", false}}, []string{"010-"}},
+	}
+	for _, testCase := range testCases {
+		bucketTree := NewRangeTree(2, bucketDebrisFactory)
+		bucketTree.Update(newSimpleBucketItem([]byte("000"), []byte("010")))
+		bucketTree.Update(newSimpleBucketItem([]byte(testCase.start), []byte(testCase.end)))
+		item := bucketTree.Find(newSimpleBucketItem([]byte(testCase.start), nil))
+		for _, s := range testCase.shrinks {
+			version := bucketTree.Version()
+			re.Equal(s.ok, bucketTree.ShrinkTo(item, []byte(s.start), []byte(s.end), rekey), s)
+			re.Equal(s.ok, bucketTree.Version() > version)
+			if s.ok {
+				item = bucketTree.Find(newSimpleBucketItem([]byte(s.start), nil))
+			}
+		}
+		var keys []string
+		bucketTree.ascend(func(item RangeItem) bool {
+			keys = append(keys, string(item.GetStartKey())+"-"+string(item.GetEndKey()))
+			return true
+		})
+		re.Equal(append([]string{"000-010"}, testCase.expect...), keys)
+	}
+	re.False(NewRangeTree(2, bucketDebrisFactory).ShrinkTo(newSimpleBucketItem([]byte("010"), nil), []byte("020"), []byte("030"), rekey))
+}
+
+func TestPrune(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Empty(bucketTree.Prune(big.NewInt(10)))
+	bucketTree.Update(newSimpleBucketItem([]byte{10}, []byte{12}))
+	bucketTree.Update(newSimpleBucketItem([]byte{12}, []byte{30}))
+	bucketTree.Update(newSimpleBucketItem([]byte{30}, []byte{39}))
+	bucketTree.Update(newSimpleBucketItem([]byte{40}, []byte{50}))
+	bucketTree.Update(newSimpleBucketItem([]byte{50}, []byte{50, 1}))
+	bucketTree.Update(newSimpleBucketItem([]byte{60}, []byte("")))
+
+	re.Empty(bucketTree.Prune(big.NewInt(1)))
+	re.Equal(6, bucketTree.Len())
+	var pruned []byte
+	// the keys are padded to 2 bytes by the end key of [50, 50 01).
+	for _, item := range bucketTree.Prune(big.NewInt(10 << 8)) {
+		pruned = append(pruned, item.GetStartKey()[0])
+	}
+	// the item of exactly 10 keys is kept, and so is the unbounded item.
+	re.Equal([]byte{10, 30, 50}, pruned)
+	re.Equal(3, bucketTree.Len())
+	re.Nil(bucketTree.Find(newSimpleBucketItem([]byte{10}, nil)))
+	re.NotNil(bucketTree.Find(newSimpleBucketItem([]byte{12}, nil)))
+	re.NotNil(bucketTree.Find(newSimpleBucketItem([]byte{40}, nil)))
+	re.NotNil(bucketTree.Find(newSimpleBucketItem([]byte{60}, nil)))
+
+	// the short keys are padded to the longest key of the tree, so [a, b) is 0x010000 keys long.
+	mixed := NewRangeTree(2, bucketDebrisFactory)
+	mixed.Update(newSimpleBucketItem([]byte("a"), []byte("b")))
+	mixed.Update(newSimpleBucketItem([]byte("c\x00"), []byte("c\x00\x05")))
+	pruned = pruned[:0]
+	for _, item := range mixed.Prune(big.NewInt(6)) {
+		pruned = append(pruned, item.GetStartKey()...)
+	}
+	re.Equal([]byte("c\x00"), pruned)
+	re.Equal(1, mixed.Len())
+}
+
+func TestRetain(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, payloadDebrisFactory)
+	re.Empty(bucketTree.Retain(func(RangeItem) bool { return false }))
+	for i := 0; i < 10; i++ {
+		flag := "drop"
+		if i%3 == 0 {
+			flag = "keep"
+		}
+		bucketTree.Update(newPayloadItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10)), flag))
+	}
+	keep := func(item RangeItem) bool { return item.(*payloadItem).payload == "keep" }
+	re.Empty(bucketTree.Retain(func(RangeItem) bool { return true }))
+	re.Equal(10, bucketTree.Len())
+
+	removed := bucketTree.Retain(keep)
+	re.Len(removed, 6)
+	for _, item := range removed {
+		re.False(keep(item))
+	}
+	re.Equal(4, bucketTree.Len())
+	var kept []string
+	bucketTree.ScanRange(newPayloadItem([]byte(""), nil, ""), func(item RangeItem) bool {
+		re.True(keep(item))
+		kept = append(kept, string(item.GetStartKey()))
+		return true
+	})
+	re.Equal([]string{"000", "030", "060", "090"}, kept)
+	re.Empty(bucketTree.Retain(keep))
+}
+
+func TestRemovePrefix(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	keys := func(tree *RangeTree) []string {
+		var res []string
+		tree.ascend(func(item RangeItem) bool {
+			res = append(res, fmt.Sprintf("%x-%x", item.GetStartKey(), item.GetEndKey()))
+			return true
+		})
+		return res
+	}
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	bucketTree.Update(newSimpleBucketItem([]byte{0x01, 0x00}, []byte{0x01, 0x02, 0x05}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x01, 0x02, 0x05}, []byte{0x01, 0x02, 0x10}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x01, 0x02, 0x10}, []byte{0x01, 0x03, 0x05}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x01, 0xff, 0x01}, []byte{0x02, 0x01}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0xff, 0x01}, []byte{0xff, 0x02}))
+
+	// a multi-byte prefix clips the straddling items.
+	re.Empty(bucketTree.RemovePrefix([]byte{0x01, 0x04}))
+	version := bucketTree.Version()
+	removed := bucketTree.RemovePrefix([]byte{0x01, 0x02})
+	re.Len(removed, 3)
+	re.Greater(bucketTree.Version(), version)
+	re.Equal([]string{"0100-0102", "0103-010305", "01ff01-0201", "ff01-ff02"}, keys(bucketTree))
+	// the prefix ending with 0xFF.
+	re.Len(bucketTree.RemovePrefix([]byte{0x01, 0xff}), 1)
+	re.Equal([]string{"0100-0102", "0103-010305", "02-0201", "ff01-ff02"}, keys(bucketTree))
+	// the prefix of all 0xFF bytes is unbounded.
+	re.Len(bucketTree.RemovePrefix([]byte{0xff}), 1)
+	re.Equal([]string{"0100-0102", "0103-010305", "02-0201"}, keys(bucketTree))
+}
+
+func TestRemoveRangeInclusive(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	newTree := func() *RangeTree {
+		bucketTree := NewRangeTree(2, bucketDebrisFactory)
+		bucketTree.Update(newSimpleBucketItem([]byte{0x10}, []byte{0x20}))
+		bucketTree.Update(newSimpleBucketItem([]byte{0x20}, []byte{0x30}))
+		bucketTree.Update(newSimpleBucketItem([]byte{0xff}, []byte{}))
+		return bucketTree
+	}
+
+	// the item starting at the end key is only removed by the inclusive removal.
+	bucketTree := newTree()
+	re.Len(bucketTree.RemoveRange([]byte{0x00}, []byte{0x20}), 1)
+	re.Equal(2, bucketTree.Len())
+	re.NotNil(bucketTree.Find(newSimpleBucketItem([]byte{0x20}, nil)))
+	bucketTree = newTree()
+	removed := bucketTree.RemoveRangeInclusive([]byte{0x00}, []byte{0x20})
+	re.Len(removed, 2)
+	re.Equal([]byte{0x20}, removed[1].GetStartKey())
+	re.Equal(1, bucketTree.Len())
+	re.Nil(bucketTree.Find(newSimpleBucketItem([]byte{0x20}, nil)))
+
+	// the end key of all 0xFF bytes means unbounded.
+	bucketTree = newTree()
+	re.Len(bucketTree.RemoveRange([]byte{0x15}, []byte{0xff}), 2)
+	re.Equal(1, bucketTree.Len())
+	bucketTree = newTree()
+	re.Len(bucketTree.RemoveRangeInclusive([]byte{0x15}, []byte{0xff}), 3)
+	re.Zero(bucketTree.Len())
+	re.Empty(bucketTree.RemoveRangeInclusive([]byte{0x00}, []byte{0xff}))
+}
+
+func TestMergeWith(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	// existing: |000 a 020|020 b 040|     |060 c 080|
+	// incoming:      |010 x 030|   |050 y 070|
+	newTrees := func() (*RangeTree, *RangeTree) {
+		existing := NewRangeTree(2, payloadDebrisFactory)
+		existing.Update(newPayloadItem([]byte("000"), []byte("020"), "a"))
+		existing.Update(newPayloadItem([]byte("020"), []byte("040"), "b"))
+		existing.Update(newPayloadItem([]byte("060"), []byte("080"), "c"))
+		incoming := NewRangeTree(2, payloadDebrisFactory)
+		incoming.Update(newPayloadItem([]byte("010"), []byte("030"), "x"))
+		incoming.Update(newPayloadItem([]byte("050"), []byte("070"), "y"))
+		return existing, incoming
+	}
+	dump := func(tree *RangeTree) []string {
+		var items []string
+		tree.ascend(func(item RangeItem) bool {
+			items = append(items, fmt.Sprintf("%s-%s:%s", item.GetStartKey(), item.GetEndKey(), item.(*payloadItem).payload))
+			return true
+		})
+		return items
+	}
+
+	testCases := []struct {
+		name    string
+		resolve func(existing, incoming RangeItem, overlapStart, overlapEnd []byte) RangeItem
+		expect  []string
+	}{
+		{
+			name: "incoming wins",
+			resolve: func(_, _ RangeItem, _, _ []byte) RangeItem {
+				return nil
+			},
+			expect: []string{"000-010:a", "010-030:x", "030-040:b", "050-070:y", "070-080:c"},
+		},
+		{
+			name: "existing wins",
+			resolve: func(existing, _ RangeItem, overlapStart, overlapEnd []byte) RangeItem {
+				return newPayloadItem(overlapStart, overlapEnd, existing.(*payloadItem).payload)
+			},
+			expect: []string{"000-010:a", "010-020:a", "020-030:b", "030-040:b", "050-060:y", "060-070:c", "070-080:c"},
+		},
+		{
+			name: "max payload wins",
+			resolve: func(existing, incoming RangeItem, overlapStart, overlapEnd []byte) RangeItem {
+				payload := existing.(*payloadItem).payload
+				if p := incoming.(*payloadItem).payload; p > payload {
+					payload = p
+				}
+				return newPayloadItem(overlapStart, overlapEnd, payload)
+			},
+			expect: []string{"000-010:a", "010-020:x", "020-030:x", "030-040:b", "050-060:y", "060-070:y", "070-080:c"},
+		},
+		{
+			name: "combined",
+			resolve: func(existing, incoming RangeItem, overlapStart, overlapEnd []byte) RangeItem {
+				return newPayloadItem(overlapStart, overlapEnd, existing.(*payloadItem).payload+incoming.(*payloadItem).payload)
+			},
+			expect: []string{"000-010:a", "010-020:ax", "020-030:bx", "030-040:b", "050-060:y", "060-070:cy", "070-080:c"},
+		},
+	}
+	for _, testCase := range testCases {
+		existing, incoming := newTrees()
+		existing.MergeWith(incoming, testCase.resolve)
+		re.Equal(testCase.expect, dump(existing), testCase.name)
+		re.Equal(2, incoming.Len())
+	}
+}
+
+func TestDiffStream(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, payloadDebrisFactory)
+	bucketTree.Update(newPayloadItem([]byte("000"), []byte("010"), "a"))
+	bucketTree.Update(newPayloadItem([]byte("010"), []byte("020"), "a"))
+	bucketTree.Update(newPayloadItem([]byte("030"), []byte("040"), "a"))
+	bucketTree.Update(newPayloadItem([]byte("050"), []byte("060"), "a"))
+	bucketTree.Update(newPayloadItem([]byte("070"), []byte(""), "a"))
+	stream := func(items ...RangeItem) func() (RangeItem, bool) {
+		return func() (RangeItem, bool) {
+			if len(items) == 0 {
+				return nil, false
+			}
+			item := items[0]
+			items = items[1:]
+			return item, true
+		}
+	}
+	payloadEqual := func(a, b RangeItem) bool {
+		return a.(*payloadItem).payload == b.(*payloadItem).payload
+	}
+	keys := func(items []RangeItem) []string {
+		var res []string
+		for _, item := range items {
+			res = append(res, fmt.Sprintf("%s-%s:%s", item.GetStartKey(), item.GetEndKey(), item.(*payloadItem).payload))
+		}
+		return res
+	}
+
+	added, removed := bucketTree.DiffStream(stream(
+		newPayloadItem([]byte("000"), []byte("010"), "a"),
+		// a payload difference
+		newPayloadItem([]byte("010"), []byte("020"), "b"),
+		// interleaved keys
+		newPayloadItem([]byte("025"), []byte("030"), "a"),
+		newPayloadItem([]byte("050"), []byte("055"), "a"),
+		newPayloadItem([]byte("070"), []byte(""), "a"),
+		newPayloadItem([]byte("080"), []byte(""), "a"),
+	), payloadEqual)
+	re.Equal([]string{"010-020:b", "025-030:a", "050-055:a", "080-:a"}, keys(added))
+	re.Equal([]string{"010-020:a", "030-040:a", "050-060:a"}, keys(removed))
+
+	added, removed = bucketTree.DiffStream(stream(), payloadEqual)
+	re.Empty(added)
+	re.Len(removed, 5)
+	added, removed = NewRangeTree(2, payloadDebrisFactory).DiffStream(stream(
+		newPayloadItem([]byte("000"), []byte("010"), "a"),
+	), payloadEqual)
+	re.Equal([]string{"000-010:a"}, keys(added))
+	re.Empty(removed)
+}
+
+func TestClamp(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	type clampCase struct {
+		start, end string
+		cs, ce     string
+		ok         bool
+	}
+	check := func(tree *RangeTree, testCases []clampCase) {
+		for _, testCase := range testCases {
+			cs, ce, ok := tree.Clamp([]byte(testCase.start), []byte(testCase.end))
+			re.Equal(testCase.ok, ok, testCase)
+			re.Equal(testCase.cs, string(cs), testCase)
+			re.Equal(testCase.ce, string(ce), testCase)
+		}
+	}
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	check(bucketTree, []clampCase{{"", "", "", "", false}})
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	bucketTree.Update(newSimpleBucketItem([]byte("040"), []byte("050")))
+	check(bucketTree, []clampCase{
+		// the query extends beyond the tree on both sides.
+		{"", "", "010", "050", true},
+		{"000", "090", "010", "050", true},
+		{"015", "090", "015", "050", true},
+		{"000", "045", "010", "045", true},
+		{"015", "045", "015", "045", true},
+		// the query is outside the tree.
+		{"050", "", "", "", false},
+		{"000", "010", "", "", false},
+	})
+	// the unbounded tail is kept.
+	bucketTree.Update(newSimpleBucketItem([]byte("060"), []byte("")))
+	check(bucketTree, []clampCase{
+		{"", "", "010", "", true},
+		{"000", "090", "010", "090", true},
+		{"050", "", "050", "", true},
+		{"000", "010", "", "", false},
+	})
+}
+
+func TestCompactSlice(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	bucketTree.Update(newPayloadItem([]byte("000"), []byte("010"), "a"))
+	bucketTree.Update(newPayloadItem([]byte("010"), []byte("020"), "a"))
+	bucketTree.Update(newPayloadItem([]byte("020"), []byte("030"), "a"))
+	// separated by a payload difference
+	bucketTree.Update(newPayloadItem([]byte("030"), []byte("040"), "b"))
+	bucketTree.Update(newPayloadItem([]byte("040"), []byte("050"), "a"))
+	// separated by a gap
+	bucketTree.Update(newPayloadItem([]byte("060"), []byte("070"), "a"))
+	bucketTree.Update(newPayloadItem([]byte("070"), []byte(""), "a"))
+
+	equal := func(a, b RangeItem) bool {
+		return a.(*payloadItem).payload == b.(*payloadItem).payload
+	}
+	merge := func(a, b RangeItem) RangeItem {
+		return newPayloadItem(a.GetStartKey(), b.GetEndKey(), a.(*payloadItem).payload)
+	}
+	items := bucketTree.CompactSlice(equal, merge)
+	expected := []*payloadItem{
+		newPayloadItem([]byte("000"), []byte("030"), "a"),
+		newPayloadItem([]byte("030"), []byte("040"), "b"),
+		newPayloadItem([]byte("040"), []byte("050"), "a"),
+		newPayloadItem([]byte("060"), []byte(""), "a"),
+	}
+	re.Len(items, len(expected))
+	for i, item := range items {
+		re.Equal(expected[i], item)
+	}
+	// the tree is not modified.
+	re.Equal(7, bucketTree.Len())
+}
+
+func TestRebaseKeys(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	bucketTree.Update(newSimpleBucketItem([]byte(""), []byte("010")))
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	bucketTree.Update(newSimpleBucketItem([]byte("030"), []byte("")))
+
+	prefix := []byte("t1_")
+	addPrefix := func(key []byte) []byte {
+		return append(append([]byte(nil), prefix...), key...)
+	}
+	newItem := func(_ RangeItem, newStart, newEnd []byte) RangeItem {
+		return newSimpleBucketItem(newStart, newEnd)
+	}
+	rebased := bucketTree.RebaseKeys(addPrefix, newItem)
+	re.Equal(3, rebased.Len())
+	re.Equal(3, bucketTree.Len())
+	re.Equal([]byte("t1_"), rebased.GetAt(0).GetStartKey())
+	re.Equal([]byte("t1_010"), rebased.GetAt(0).GetEndKey())
+	re.Equal([]byte("t1_010"), rebased.GetAt(1).GetStartKey())
+	re.Equal([]byte("t1_020"), rebased.GetAt(1).GetEndKey())
+	re.Equal([]byte("t1_030"), rebased.GetAt(2).GetStartKey())
+	re.Empty(rebased.GetAt(2).GetEndKey())
+	re.Len(rebased.GetOverlapsInRange([]byte("t1_015"), []byte("t1_035")), 2)
+
+	// strip the prefix back.
+	stripPrefix := func(key []byte) []byte {
+		return bytes.TrimPrefix(key, prefix)
+	}
+	restored := rebased.RebaseKeys(stripPrefix, newItem)
+	re.Equal(3, restored.Len())
+	for i := 0; i < restored.Len(); i++ {
+		re.Equal(bucketTree.GetAt(i), restored.GetAt(i))
+	}
+}
+
+func TestDegree(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	re.Equal(2, NewRangeTree(2, bucketDebrisFactory).Degree())
+	re.Equal(64, NewRangeTreeWithCapacity(64, bucketDebrisFactory, 1000).Degree())
+	tree := NewRangeTree(8, bucketDebrisFactory)
+	other := NewRangeTree(16, bucketDebrisFactory)
+	tree.Swap(other)
+	re.Equal(16, tree.Degree())
+	re.Equal(8, other.Degree())
+}
+
+func TestRank(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Zero(bucketTree.Rank([]byte("050")))
+	// key range: [010,020], [030,040], [050,060], [070,080]
+	for i := 1; i < 8; i += 2 {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+	}
+	re.Zero(bucketTree.Rank([]byte("")))
+	re.Zero(bucketTree.Rank([]byte("005")))
+	// the item starting with the key does not precede it.
+	re.Zero(bucketTree.Rank([]byte("010")))
+	re.Equal(1, bucketTree.Rank([]byte("015")))
+	re.Equal(1, bucketTree.Rank([]byte("025")))
+	re.Equal(2, bucketTree.Rank([]byte("050")))
+	re.Equal(4, bucketTree.Rank([]byte("075")))
+	re.Equal(4, bucketTree.Rank([]byte("090")))
+	// the tombstones are skipped.
+	bucketTree.SetDeferredDelete(true)
+	bucketTree.Remove(newSimpleBucketItem([]byte("030"), nil))
+	re.Equal(1, bucketTree.Rank([]byte("050")))
+	re.Equal(3, bucketTree.Rank([]byte("090")))
+}
+
+func TestSampleUniform(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Nil(bucketTree.SampleUniform(3))
+	for i := 0; i < 10; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+	}
+	starts := func(items []RangeItem) []string {
+		var res []string
+		for _, item := range items {
+			res = append(res, string(item.GetStartKey()))
+		}
+		return res
+	}
+	all := []string{"000", "010", "020", "030", "040", "050", "060", "070", "080", "090"}
+	re.Nil(bucketTree.SampleUniform(0))
+	re.Equal([]string{"000"}, starts(bucketTree.SampleUniform(1)))
+	re.Equal([]string{"000", "030", "060"}, starts(bucketTree.SampleUniform(3)))
+	re.Equal([]string{"000", "020", "040", "060", "080"}, starts(bucketTree.SampleUniform(5)))
+	re.Equal(all, starts(bucketTree.SampleUniform(10)))
+	re.Equal(all, starts(bucketTree.SampleUniform(25)))
+
+	// the tombstones are skipped.
+	bucketTree.SetDeferredDelete(true)
+	bucketTree.Remove(newSimpleBucketItem([]byte("000"), nil))
+	re.Equal([]string{"010", "040", "070"}, starts(bucketTree.SampleUniform(3)))
+	re.Equal(all[1:], starts(bucketTree.SampleUniform(9)))
+	re.Equal(all[1:], starts(bucketTree.SampleUniform(100)))
+}
+
+func TestScanFromIndex(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	for i := 0; i < 10; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+	}
+	scan := func(index, limit int) []string {
+		var keys []string
+		bucketTree.ScanFromIndex(index, func(item RangeItem) bool {
+			keys = append(keys, string(item.GetStartKey()))
+			return len(keys) < limit
+		})
+		return keys
+	}
+	re.Equal([]string{"000", "010", "020"}, scan(0, 3))
+	re.Equal([]string{"050", "060", "070", "080", "090"}, scan(5, 100))
+	re.Equal([]string{"090"}, scan(9, 100))
+	// the out-of-range indexes.
+	re.Equal([]string{"000", "010"}, scan(-5, 2))
+	re.Empty(scan(10, 100))
+	re.Empty(scan(100, 100))
+	// the tombstones are skipped.
+	bucketTree.SetDeferredDelete(true)
+	bucketTree.Remove(newSimpleBucketItem([]byte("010"), nil))
+	re.Equal([]string{"020", "030"}, scan(1, 2))
+	re.Empty(scan(9, 100))
+}
+
+func TestItemAtQuantile(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Nil(bucketTree.ItemAtQuantile(0.5))
+	for i := 0; i < 10; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+	}
+	re.Equal([]byte("000"), bucketTree.ItemAtQuantile(0).GetStartKey())
+	re.Equal([]byte("050"), bucketTree.ItemAtQuantile(0.5).GetStartKey())
+	re.Equal([]byte("090"), bucketTree.ItemAtQuantile(0.9).GetStartKey())
+	re.Equal([]byte("090"), bucketTree.ItemAtQuantile(1.0).GetStartKey())
+	// q out of [0, 1] is clamped.
+	re.Equal([]byte("000"), bucketTree.ItemAtQuantile(-1).GetStartKey())
+	re.Equal([]byte("090"), bucketTree.ItemAtQuantile(2).GetStartKey())
+}
+
+func TestIndexOf(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Equal(-1, bucketTree.IndexOf([]byte("010")))
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	bucketTree.Update(newSimpleBucketItem([]byte("020"), []byte("030")))
+	bucketTree.Update(newSimpleBucketItem([]byte("050"), []byte("060")))
+	bucketTree.Update(newSimpleBucketItem([]byte("070"), []byte("")))
+
+	// keys inside the items
+	re.Equal(0, bucketTree.IndexOf([]byte("010")))
+	re.Equal(0, bucketTree.IndexOf([]byte("019")))
+	re.Equal(1, bucketTree.IndexOf([]byte("020")))
+	re.Equal(2, bucketTree.IndexOf([]byte("055")))
+	re.Equal(3, bucketTree.IndexOf([]byte("999")))
+	// keys in the gaps
+	re.Equal(-1, bucketTree.IndexOf([]byte("000")))
+	re.Equal(-3, bucketTree.IndexOf([]byte("030")))
+	re.Equal(-3, bucketTree.IndexOf([]byte("040")))
+	re.Equal(-4, bucketTree.IndexOf([]byte("065")))
+}
+
+func TestSwap(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	other := NewRangeTree(4, func(_, _ []byte, _ RangeItem) []RangeItem { return nil })
+	other.Update(newSimpleBucketItem([]byte("100"), []byte("200")))
+	other.Update(newSimpleBucketItem([]byte("200"), []byte("300")))
+	other.Update(newSimpleBucketItem([]byte("300"), []byte("400")))
+	version, otherVersion := bucketTree.Version(), other.Version()
+
+	bucketTree.Swap(other)
+	re.Equal(3, bucketTree.Len())
+	re.Equal([]byte("100"), bucketTree.GetAt(0).GetStartKey())
+	re.Equal(1, other.Len())
+	re.Equal([]byte("010"), other.GetAt(0).GetStartKey())
+	re.Greater(bucketTree.Version(), otherVersion)
+	re.Greater(other.Version(), otherVersion)
+	re.Greater(other.Version(), version)
+	// the factory is swapped too, the new one drops the debris.
+	bucketTree.Update(newSimpleBucketItem([]byte("150"), []byte("250")))
+	re.Equal(2, bucketTree.Len())
+	other.Update(newSimpleBucketItem([]byte("015"), []byte("016")))
+	re.Equal(3, other.Len())
+}
+
+func TestPartition(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Nil(bucketTree.Partition(0))
+	for i := 0; i < 6; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+	}
+	starts := func(trees []*RangeTree) [][]string {
+		res := make([][]string, 0, len(trees))
+		for _, tree := range trees {
+			keys := []string{}
+			tree.ScanRange(newSimpleBucketItem([]byte(""), nil), func(item RangeItem) bool {
+				keys = append(keys, string(item.GetStartKey()))
+				return true
+			})
+			res = append(res, keys)
+		}
+		return res
+	}
+
+	whole := bucketTree.Partition(1)
+	re.Equal([][]string{{"000", "010", "020", "030", "040", "050"}}, starts(whole))
+	re.Equal(2, whole[0].Degree())
+	re.True(bucketTree.Equal(whole[0], func(a, b RangeItem) bool { return a == b }))
+	re.Equal([][]string{{"000", "010"}, {"020", "030"}, {"040", "050"}}, starts(bucketTree.Partition(3)))
+	re.Equal([][]string{{"000"}, {"010", "020"}, {"030"}, {"040", "050"}}, starts(bucketTree.Partition(4)))
+	re.Equal([][]string{{}, {"000"}, {}, {"010"}, {"020"}, {}, {"030"}, {}, {"040"}, {"050"}}, starts(bucketTree.Partition(10)))
+
+	// the partitions are independent of the tree and each other.
+	parts := bucketTree.Partition(2)
+	re.Len(parts[0].Update(newSimpleBucketItem([]byte("005"), []byte("025"))), 3)
+	re.Empty(parts[1].GetOverlaps(newSimpleBucketItem([]byte("005"), []byte("025"))))
+	re.Len(bucketTree.GetOverlaps(newSimpleBucketItem([]byte("005"), []byte("025"))), 3)
+	re.Equal(6, bucketTree.Len())
+	re.NoError(parts[0].Validate())
+}
+
+func TestCoalesceAll(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	newItem := func(merged KeyRange) RangeItem {
+		return newPayloadItem(merged.StartKey, merged.EndKey, "merged")
+	}
+	bucketTree := NewRangeTree(2, payloadDebrisFactory)
+	re.Zero(bucketTree.CoalesceAll(newItem))
+	for i := 0; i < 10; i++ {
+		bucketTree.Update(newPayloadItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10)), fmt.Sprint(i)))
+	}
+	items := func() []string {
+		var res []string
+		bucketTree.ScanRange(newPayloadItem([]byte(""), nil, ""), func(item RangeItem) bool {
+			res = append(res, fmt.Sprintf("%s-%s:%s", item.GetStartKey(), item.GetEndKey(), item.(*payloadItem).payload))
+			return true
+		})
+		return res
+	}
+	fork, _ := bucketTree.Fork()
+
+	// the whole tree collapses into one item regardless of the payloads.
+	re.Equal(9, bucketTree.CoalesceAll(newItem))
+	re.Equal([]string{"000-100:merged"}, items())
+	re.Zero(bucketTree.CoalesceAll(newItem))
+
+	// the gaps prevent merging.
+	bucketTree = fork
+	bucketTree.Remove(newPayloadItem([]byte("030"), nil, ""))
+	bucketTree.Remove(newPayloadItem([]byte("080"), nil, ""))
+	bucketTree.Update(newPayloadItem([]byte("100"), []byte(""), "unbounded"))
+	re.Equal(6, bucketTree.CoalesceAll(newItem))
+	re.Equal([]string{"000-030:merged", "040-080:merged", "090-:merged"}, items())
+	re.NoError(bucketTree.Validate())
+}
+
+func TestFlattenInflateCoverage(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	newItem := func(kr KeyRange) RangeItem {
+		return newSimpleBucketItem(kr.StartKey, kr.EndKey)
+	}
+	bucketTree := NewRangeTree(2, payloadDebrisFactory)
+	re.Empty(bucketTree.Flatten())
+	for i := 0; i < 10; i++ {
+		if i != 3 {
+			bucketTree.Update(newPayloadItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10)), fmt.Sprint(i)))
+		}
+	}
+	bucketTree.Update(newPayloadItem([]byte("100"), []byte(""), "unbounded"))
+	ranges := bucketTree.Flatten()
+	re.Len(ranges, 10)
+	re.Equal(KeyRange{StartKey: []byte("000"), EndKey: []byte("010")}, ranges[0])
+	re.Equal(KeyRange{StartKey: []byte("040"), EndKey: []byte("050")}, ranges[3])
+	re.Equal(KeyRange{StartKey: []byte("100"), EndKey: []byte("")}, ranges[9])
+
+	// the round trip keeps the key ranges but drops the payloads.
+	inflated, err := InflateCoverage(4, bucketDebrisFactory, ranges, newItem)
+	re.NoError(err)
+	re.Equal(4, inflated.Degree())
+	re.True(inflated.EqualKeys(bucketTree))
+	re.Equal(ranges, inflated.Flatten())
+	re.NoError(inflated.Validate())
+	_, ok := inflated.GetAt(0).(*simpleBucketItem)
+	re.True(ok)
+	empty, err := InflateCoverage(2, bucketDebrisFactory, nil, newItem)
+	re.NoError(err)
+	re.Zero(empty.Len())
+
+	// the invalid key ranges.
+	for _, invalid := range [][]KeyRange{
+		{{StartKey: []byte("020"), EndKey: []byte("010")}},
+		{{StartKey: []byte("010"), EndKey: []byte("030")}, {StartKey: []byte("020"), EndKey: []byte("040")}},
+		{{StartKey: []byte("020"), EndKey: []byte("030")}, {StartKey: []byte("000"), EndKey: []byte("010")}},
+		{{StartKey: []byte("020"), EndKey: []byte("")}, {StartKey: []byte("030"), EndKey: []byte("040")}},
+	} {
+		_, err := InflateCoverage(2, bucketDebrisFactory, invalid, newItem)
+		re.Error(err)
+	}
+}
+
+func TestTrimToCapacity(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Empty(bucketTree.TrimToCapacity(0, []byte{0x50}))
+	// the distances to the pivot 0x50: 0x40, 0x20, 0x08, 0, 0x08, 0x20 and 0x30.
+	bucketTree.Update(newSimpleBucketItem([]byte{0x00}, []byte{0x10}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x20}, []byte{0x30}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x40}, []byte{0x48}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x48}, []byte{0x52}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x5a}, []byte{0x60}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x70}, []byte{0x78}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x80}, []byte("")))
+	starts := func(items []RangeItem) []byte {
+		var res []byte
+		for _, item := range items {
+			res = append(res, item.GetStartKey()[0])
+		}
+		return res
+	}
+	re.Empty(bucketTree.TrimToCapacity(7, []byte{0x50}))
+	re.Equal([]byte{0x00, 0x80}, starts(bucketTree.TrimToCapacity(5, []byte{0x50})))
+	// the tie of 0x20 is broken by removing the greater key.
+	re.Equal([]byte{0x70, 0x20}, starts(bucketTree.TrimToCapacity(3, []byte{0x50})))
+	re.Equal([]byte{0x5a}, starts(bucketTree.TrimToCapacity(2, []byte{0x50})))
+	re.Equal([]byte{0x40, 0x48}, starts(bucketTree.GetOverlapsInRange(nil, nil)))
+	re.Equal([]byte{0x40, 0x48}, starts(bucketTree.TrimToCapacity(-1, []byte{0x50})))
+	re.Zero(bucketTree.Len())
+
+	// the keys of different lengths are compared as the same length.
+	bucketTree.Update(newSimpleBucketItem([]byte{0x10}, []byte{0x20}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x50, 0x01}, []byte{0x60}))
+	re.Equal([]byte{0x10}, starts(bucketTree.TrimToCapacity(1, []byte{0x50})))
+	// the pivot before all items.
+	bucketTree.Update(newSimpleBucketItem([]byte{0x10}, []byte{0x20}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x30}, []byte{0x40}))
+	re.Equal([]byte{0x50, 0x30}, starts(bucketTree.TrimToCapacity(1, []byte{0x00})))
+}
+
+func TestFork(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	for i := 0; i < 20; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+	}
+	bucketTree.SetDeferredDelete(true)
+	bucketTree.Remove(newSimpleBucketItem([]byte("000"), nil))
+	parent, child := bucketTree.Fork()
+	re.Equal(19, parent.Len())
+	re.Equal(19, child.Len())
+	re.Equal(bucketTree.Version(), child.Version())
+
+	// mutate every fork independently.
+	parent.Update(newSimpleBucketItem([]byte("015"), []byte("055")))
+	re.Equal(17, parent.Len())
+	re.Equal(19, child.Len())
+	re.Equal(19, bucketTree.Len())
+	child.Remove(newSimpleBucketItem([]byte("100"), nil))
+	child.Compact()
+	child.Update(newSimpleBucketItem([]byte("000"), []byte("005")))
+	re.Equal(19, child.Len())
+	re.Nil(child.Find(newSimpleBucketItem([]byte("105"), nil)))
+	re.NotNil(parent.Find(newSimpleBucketItem([]byte("105"), nil)))
+	re.Nil(parent.Find(newSimpleBucketItem([]byte("000"), nil)))
+	re.Equal([]byte("015"), parent.Find(newSimpleBucketItem([]byte("020"), nil)).GetStartKey())
+	re.Equal([]byte("020"), child.Find(newSimpleBucketItem([]byte("020"), nil)).GetStartKey())
+	// the original tree is not affected and still mutable.
+	re.Nil(bucketTree.Find(newSimpleBucketItem([]byte("000"), nil)))
+	re.NotNil(bucketTree.Find(newSimpleBucketItem([]byte("105"), nil)))
+	bucketTree.Update(newSimpleBucketItem([]byte("100"), []byte("200")))
+	re.Equal(10, bucketTree.Len())
+	re.Equal(17, parent.Len())
+	re.Equal(19, child.Len())
+}
+
+func TestEqual(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	comparisons := 0
+	payloadEqual := func(a, b RangeItem) bool {
+		comparisons++
+		return a.(*payloadItem).payload == b.(*payloadItem).payload
+	}
+	bucketTree := NewRangeTree(2, payloadDebrisFactory)
+	other := NewRangeTree(4, payloadDebrisFactory)
+	re.True(bucketTree.Equal(other, payloadEqual))
+	for i := 0; i < 20; i++ {
+		bucketTree.Update(newPayloadItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10)), "a"))
+		other.Update(newPayloadItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10)), "a"))
+	}
+	re.True(bucketTree.Equal(other, payloadEqual))
+	re.Equal(20, comparisons)
+	other.Update(newPayloadItem([]byte("050"), []byte("060"), "b"))
+	re.False(bucketTree.Equal(other, payloadEqual))
+	other.Update(newPayloadItem([]byte("050"), []byte("055"), "a"))
+	re.False(bucketTree.Equal(other, payloadEqual))
+
+	// the forks not mutated are equal without comparing the items.
+	comparisons = 0
+	parent, child := bucketTree.Fork()
+	re.True(parent.Equal(child, payloadEqual))
+	re.True(child.Equal(parent, payloadEqual))
+	re.True(bucketTree.Equal(child, payloadEqual))
+	re.Zero(comparisons)
+	child.Update(newPayloadItem([]byte("050"), []byte("060"), "a"))
+	re.True(parent.Equal(child, payloadEqual))
+	re.Equal(20, comparisons)
+	child.Update(newPayloadItem([]byte("050"), []byte("060"), "b"))
+	re.False(parent.Equal(child, payloadEqual))
+	// the tombstones are not shared.
+	parent, child = bucketTree.Fork()
+	child.SetDeferredDelete(true)
+	child.Remove(newPayloadItem([]byte("050"), nil, ""))
+	re.False(parent.Equal(child, payloadEqual))
+	child.Update(newPayloadItem([]byte("050"), []byte("060"), "a"))
+	re.True(parent.Equal(child, payloadEqual))
+}
+
+func TestEqualKeys(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, payloadDebrisFactory)
+	other := NewRangeTree(4, payloadDebrisFactory)
+	re.True(bucketTree.EqualKeys(other))
+	for i := 0; i < 20; i++ {
+		bucketTree.Update(newPayloadItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10)), "a"))
+		other.Update(newPayloadItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10)), fmt.Sprint(i)))
+	}
+	// the payloads are different.
+	re.True(bucketTree.EqualKeys(other))
+	re.True(other.EqualKeys(bucketTree))
+	re.False(bucketTree.Equal(other, func(a, b RangeItem) bool {
+		return a.(*payloadItem).payload == b.(*payloadItem).payload
+	}))
+	// the coverages are different.
+	other.Update(newPayloadItem([]byte("050"), []byte("055"), "a"))
+	re.False(bucketTree.EqualKeys(other))
+	other.Update(newPayloadItem([]byte("050"), []byte("060"), "b"))
+	re.True(bucketTree.EqualKeys(other))
+	other.Remove(newPayloadItem([]byte("190"), nil, ""))
+	re.False(bucketTree.EqualKeys(other))
+	other.Update(newPayloadItem([]byte("190"), []byte("210"), "b"))
+	re.False(bucketTree.EqualKeys(other))
+}
+
+func TestEqualWithin(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	trimmedEqual := func(a, b []byte) bool {
+		return bytes.Equal(bytes.TrimRight(a, "\x00"), bytes.TrimRight(b, "\x00"))
+	}
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	padded := NewRangeTree(4, bucketDebrisFactory)
+	re.True(bucketTree.EqualWithin(padded, trimmedEqual))
+	for i := 1; i < 10; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte{byte(i * 0x10)}, []byte{byte(i*0x10 + 0x10)}))
+		padded.Update(newSimpleBucketItem([]byte{byte(i * 0x10), 0x00}, []byte{byte(i*0x10 + 0x10), 0x00, 0x00}))
+	}
+	// the keys differ only by the trailing zeros.
+	re.True(bucketTree.EqualWithin(padded, trimmedEqual))
+	re.True(padded.EqualWithin(bucketTree, trimmedEqual))
+	re.False(bucketTree.EqualWithin(padded, bytes.Equal))
+	re.False(bucketTree.EqualKeys(padded))
+
+	padded.Update(newSimpleBucketItem([]byte{0x50, 0x00}, []byte{0x58}))
+	re.False(bucketTree.EqualWithin(padded, trimmedEqual))
+	padded.Update(newSimpleBucketItem([]byte{0x50}, []byte{0x60, 0x00, 0x00}))
+	re.True(bucketTree.EqualWithin(padded, trimmedEqual))
+	// the unbounded end key only equals the unbounded end key.
+	bucketTree.Update(newSimpleBucketItem([]byte{0xa0}, []byte("")))
+	padded.Update(newSimpleBucketItem([]byte{0xa0, 0x00}, []byte{0xff}))
+	re.False(bucketTree.EqualWithin(padded, func(a, b []byte) bool { return true }))
+	padded.Remove(newSimpleBucketItem([]byte{0xa0, 0x00}, nil))
+	padded.Update(newSimpleBucketItem([]byte{0xa0, 0x00}, []byte("")))
+	re.True(bucketTree.EqualWithin(padded, trimmedEqual))
+}
+
+func TestKNearest(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Empty(bucketTree.KNearest([]byte{0x50}, 3))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x10}, []byte{0x20}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x30}, []byte{0x40}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x48}, []byte{0x51}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x60}, []byte{0x70}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x90}, []byte{}))
+	startKeys := func(items []RangeItem) []byte {
+		var keys []byte
+		for _, item := range items {
+			keys = append(keys, item.GetStartKey()[0])
+		}
+		return keys
+	}
+
+	// the key is inside an item.
+	re.Equal([]byte{0x30}, startKeys(bucketTree.KNearest([]byte{0x35}, 1)))
+	// distances: 0x30 -> 0, 0x48 -> 0x13, 0x10 -> 0x16, 0x60 -> 0x2b.
+	re.Equal([]byte{0x30, 0x48, 0x10, 0x60}, startKeys(bucketTree.KNearest([]byte{0x35}, 4)))
+	// the key is in a gap, distances: 0x48 -> 0x06, 0x60 -> 0x0a, 0x30 -> 0x17.
+	re.Equal([]byte{0x48, 0x60, 0x30}, startKeys(bucketTree.KNearest([]byte{0x56}, 3)))
+	// ties: 0x48 -> 0x08, 0x60 -> 0x08.
+	re.Equal([]byte{0x48, 0x60}, startKeys(bucketTree.KNearest([]byte{0x58}, 2)))
+	// the key equals a start key.
+	re.Equal([]byte{0x60, 0x48}, startKeys(bucketTree.KNearest([]byte{0x60}, 2)))
+	// the unbounded item contains all keys after its start.
+	re.Equal([]byte{0x90, 0x60}, startKeys(bucketTree.KNearest([]byte{0xff, 0xff}, 2)))
+	// fewer than k items are available.
+	re.Equal([]byte{0x10, 0x30, 0x48, 0x60, 0x90}, startKeys(bucketTree.KNearest([]byte{0x00}, 10)))
+	re.Empty(bucketTree.KNearest([]byte{0x00}, 0))
+
+	// the distances are compared with the keys padded to the same length, distances: 0x61 -> 0x02,
+	// 0x63 -> 0x0100.
+	mixed := NewRangeTree(2, bucketDebrisFactory)
+	mixed.Update(newSimpleBucketItem([]byte("a"), []byte("a\xff")))
+	mixed.Update(newSimpleBucketItem([]byte("c"), []byte("d")))
+	re.Equal([]byte("ac"), startKeys(mixed.KNearest([]byte("b"), 2)))
+	// the item ending at the key is 1 away from it.
+	re.Equal([]byte("ca"), startKeys(mixed.KNearest([]byte("d"), 2)))
+}
+
+func TestWalkWithNeighbors(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	bucketTree.WalkWithNeighbors(func(_, _, _ RangeItem) bool {
+		re.Fail("should not be called on an empty tree")
+		return true
+	})
+	for i := 0; i < 5; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+	}
+	startKey := func(item RangeItem) string {
+		if item == nil {
+			return "nil"
+		}
+		return string(item.GetStartKey())
+	}
+	var walked []string
+	bucketTree.WalkWithNeighbors(func(prev, curr, next RangeItem) bool {
+		walked = append(walked, fmt.Sprintf("%s-%s-%s", startKey(prev), startKey(curr), startKey(next)))
+		return true
+	})
+	re.Equal([]string{"nil-000-010", "000-010-020", "010-020-030", "020-030-040", "030-040-nil"}, walked)
+
+	// stop when f returns false.
+	walked = walked[:0]
+	bucketTree.WalkWithNeighbors(func(prev, curr, next RangeItem) bool {
+		walked = append(walked, startKey(curr))
+		return len(walked) < 2
+	})
+	re.Equal([]string{"000", "010"}, walked)
+
+	// a single item has no neighbors.
+	single := NewRangeTree(2, bucketDebrisFactory)
+	single.Update(newSimpleBucketItem([]byte("000"), []byte("")))
+	walked = walked[:0]
+	single.WalkWithNeighbors(func(prev, curr, next RangeItem) bool {
+		walked = append(walked, fmt.Sprintf("%s-%s-%s", startKey(prev), startKey(curr), startKey(next)))
+		return true
+	})
+	re.Equal([]string{"nil-000-nil"}, walked)
+}
+
+func TestSetCapacity(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	// evict the smallest item.
+	evictSmallest := func(tree *RangeTree) RangeItem {
+		var smallest RangeItem
+		tree.ScanRange(newSimpleBucketItem(nil, nil), func(item RangeItem) bool {
+			if smallest == nil || keyDistance(item.GetStartKey(), item.GetEndKey(), 1).Cmp(
+				keyDistance(smallest.GetStartKey(), smallest.GetEndKey(), 1)) < 0 {
+				smallest = item
+			}
+			return true
+		})
+		return smallest
+	}
+	bucketTree.Update(newSimpleBucketItem([]byte{0x00}, []byte{0x10}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x10}, []byte{0x12}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x20}, []byte{0x25}))
+	bucketTree.SetCapacity(2, evictSmallest)
+	re.Equal(2, bucketTree.Len())
+	re.Nil(bucketTree.Find(newSimpleBucketItem([]byte{0x11}, nil)))
+
+	bucketTree.Update(newSimpleBucketItem([]byte{0x30}, []byte{0x33}))
+	re.Equal(2, bucketTree.Len())
+	re.Nil(bucketTree.Find(newSimpleBucketItem([]byte{0x31}, nil)))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x30}, []byte{0x40}))
+	re.Equal(2, bucketTree.Len())
+	re.Nil(bucketTree.Find(newSimpleBucketItem([]byte{0x21}, nil)))
+	re.NotNil(bucketTree.Find(newSimpleBucketItem([]byte{0x31}, nil)))
+
+	// an update splitting an item may evict the debris.
+	bucketTree.Update(newSimpleBucketItem([]byte{0x05}, []byte{0x0f}))
+	re.Equal(2, bucketTree.Len())
+	re.NotNil(bucketTree.Find(newSimpleBucketItem([]byte{0x05}, nil)))
+
+	// the eviction stops if evict returns nil.
+	bucketTree.SetCapacity(1, func(*RangeTree) RangeItem { return nil })
+	re.Equal(2, bucketTree.Len())
+	// no limit
+	bucketTree.SetCapacity(0, nil)
+	bucketTree.Update(newSimpleBucketItem([]byte{0x50}, []byte{0x60}))
+	re.Equal(3, bucketTree.Len())
+}
+
+func TestPutOverlaps(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	for i := 0; i < 10; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+	}
+	overlaps := bucketTree.GetOverlaps(newSimpleBucketItem([]byte("000"), []byte("050")))
+	re.Len(overlaps, 5)
+	PutOverlaps(overlaps)
+	re.Nil(bucketTree.GetOverlaps(newSimpleBucketItem([]byte("100"), []byte("200"))))
+	overlaps = bucketTree.GetOverlaps(newSimpleBucketItem([]byte("050"), []byte("070")))
+	re.Len(overlaps, 2)
+	re.Equal([]byte("050"), overlaps[0].GetStartKey())
+	re.Equal([]byte("060"), overlaps[1].GetStartKey())
+	PutOverlaps(overlaps)
+	PutOverlaps(nil)
+}
+
+func TestApplyDelta(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	for i := 0; i < 5; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+	}
+	items := func(items []RangeItem) []string {
+		var res []string
+		for _, item := range items {
+			res = append(res, string(item.GetStartKey())+"-"+string(item.GetEndKey()))
+		}
+		return res
+	}
+	inserts := []RangeItem{
+		newSimpleBucketItem([]byte("035"), []byte("045")),
+		newSimpleBucketItem([]byte("020"), []byte("025")),
+		newSimpleBucketItem([]byte("005"), []byte("015")),
+		newSimpleBucketItem([]byte("012"), []byte("020")),
+		newSimpleBucketItem([]byte("030"), []byte("032")),
+	}
+	deletes := []RangeItem{
+		newSimpleBucketItem([]byte("030"), nil),
+		newSimpleBucketItem([]byte("090"), nil),
+		newSimpleBucketItem([]byte("010"), nil),
+		// the insert of the deleted key range survives.
+		newSimpleBucketItem([]byte("020"), nil),
+	}
+	insertsCopy, deletesCopy := append([]RangeItem(nil), inserts...), append([]RangeItem(nil), deletes...)
+	displaced := bucketTree.ApplyDelta(inserts, deletes)
+	re.Equal(insertsCopy, inserts)
+	re.Equal(deletesCopy, deletes)
+	// [005,015] displaces [000,010], then is displaced by [012,020].
+	re.Equal([]string{"000-010", "005-015", "040-050"}, items(displaced))
+	re.Equal([]string{"000-005", "005-012", "012-020", "020-025", "030-032", "035-045", "045-050"},
+		items(bucketTree.GetOverlapsInRange([]byte(""), []byte(""))))
+
+	re.Empty(bucketTree.ApplyDelta(nil, nil))
+	re.Empty(bucketTree.ApplyDelta(nil, []RangeItem{newSimpleBucketItem([]byte("000"), nil)}))
+	re.Equal(6, bucketTree.Len())
+}
+
+func TestLoadFromChan(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	ch := make(chan RangeItem)
+	go func() {
+		for i := 0; i < 100; i++ {
+			ch <- newSimpleBucketItem([]byte(fmt.Sprintf("%04d", i*10)), []byte(fmt.Sprintf("%04d", i*10+10)))
+		}
+		// the later item overrides the overlapping ones.
+		ch <- newSimpleBucketItem([]byte("0105"), []byte("0205"))
+		close(ch)
+	}()
+	re.NoError(bucketTree.LoadFromChan(context.Background(), ch))
+	// [0110, 0200] are enclosed by [0105, 0205] and deleted, [0100, 0110] and [0200, 0210] are clipped.
+	re.Equal(92, bucketTree.Len())
+	re.NoError(bucketTree.Validate())
+	re.Equal([]byte("0205"), bucketTree.Find(newSimpleBucketItem([]byte("0150"), nil)).GetEndKey())
+	re.Equal([]byte("0100"), bucketTree.Find(newSimpleBucketItem([]byte("0100"), nil)).GetStartKey())
+	re.Equal([]byte("0105"), bucketTree.Find(newSimpleBucketItem([]byte("0100"), nil)).GetEndKey())
+
+	// the received items are kept if the context is canceled.
+	ctx, cancel := context.WithCancel(context.Background())
+	ch = make(chan RangeItem)
+	done := make(chan error)
+	go func() {
+		done <- bucketTree.LoadFromChan(ctx, ch)
+	}()
+	ch <- newSimpleBucketItem([]byte("0990"), []byte("0995"))
+	cancel()
+	re.ErrorIs(<-done, context.Canceled)
+	re.Equal([]byte("0995"), bucketTree.Find(newSimpleBucketItem([]byte("0990"), nil)).GetEndKey())
+}
+
+func TestUpdateWithPolicy(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	for i := 1; i <= 4; i++ {
+		bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+	}
+	items := func() []string {
+		var res []string
+		bucketTree.ScanRange(newSimpleBucketItem([]byte(""), nil), func(item RangeItem) bool {
+			res = append(res, string(item.GetStartKey())+"-"+string(item.GetEndKey()))
+			return true
+		})
+		return res
+	}
+	keys := func(items []RangeItem) []string {
+		var res []string
+		for _, item := range items {
+			res = append(res, string(item.GetStartKey()))
+		}
+		return res
+	}
+	re.Equal([]string{"010-020", "020-030", "030-040", "040-050"}, items())
+
+	var observed []string
+	bucketTree.SetDebrisObserver(func(src RangeItem, children []RangeItem) {
+		observed = append(observed, fmt.Sprintf("%s:%d", src.GetStartKey(), len(children)))
+	})
+	actions := map[string]OverlapAction{"010": OverlapSplit, "020": OverlapDelete, "030": OverlapSkip, "040": OverlapSplit}
+	overlaps := bucketTree.UpdateWithPolicy(newSimpleBucketItem([]byte("015"), []byte("045")), func(overlap RangeItem) OverlapAction {
+		return actions[string(overlap.GetStartKey())]
+	})
+	re.Equal([]string{"010", "020", "040"}, keys(overlaps))
+	re.Equal([]string{"010:1", "020:0", "040:1"}, observed)
+	// the skipped [030,040] is kept overlapping with the updated item, the corrupted tree is not queried
+	// by GetOverlaps since it panics with the rangetree_debug build tag.
+	re.Equal([]string{"010-015", "015-045", "030-040", "045-050"}, items())
+	re.Error(bucketTree.Validate())
+	re.NotNil(bucketTree.Remove(newSimpleBucketItem([]byte("030"), nil)))
+	re.NoError(bucketTree.Validate())
+
+	// the skipped overlap with the same start key is deleted.
+	overlaps = bucketTree.UpdateWithPolicy(newSimpleBucketItem([]byte("015"), []byte("020")), func(RangeItem) OverlapAction {
+		return OverlapSkip
+	})
+	re.Equal([]string{"015"}, keys(overlaps))
+	re.Equal([]string{"010-015", "015-020", "045-050"}, items())
+
+	// the policy always splitting is the same as Update.
+	overlaps = bucketTree.UpdateWithPolicy(newSimpleBucketItem([]byte("012"), []byte("047")), func(RangeItem) OverlapAction {
+		return OverlapSplit
+	})
+	re.Equal([]string{"010", "015", "045"}, keys(overlaps))
+	re.Equal([]string{"010-012", "012-047", "047-050"}, items())
+	re.NoError(bucketTree.Validate())
+}
+
+func TestGetOrInsert(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	clipped := 0
+	bucketTree.SetDebrisObserver(func(RangeItem, []RangeItem) {
+		clipped++
+	})
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("030")))
+
+	// the hit path returns the existing item without splitting it.
+	version := bucketTree.Version()
+	actual, inserted := bucketTree.GetOrInsert(newSimpleBucketItem([]byte("010"), []byte("020")))
+	re.False(inserted)
+	re.Equal([]byte("030"), actual.GetEndKey())
+	re.Equal(version, bucketTree.Version())
+	re.Zero(clipped)
+	re.Equal(1, bucketTree.Len())
+
+	// the miss path updates the tree.
+	item := newSimpleBucketItem([]byte("020"), []byte("040"))
+	actual, inserted = bucketTree.GetOrInsert(item)
+	re.True(inserted)
+	re.Equal(item, actual)
+	re.Equal(1, clipped)
+	re.Equal(2, bucketTree.Len())
+	re.Equal([]byte("020"), bucketTree.Find(newSimpleBucketItem([]byte("010"), nil)).GetEndKey())
+	actual, inserted = bucketTree.GetOrInsert(newSimpleBucketItem([]byte("020"), []byte("")))
+	re.False(inserted)
+	re.Equal(item, actual)
+
+	// a tombstone is not a hit.
+	bucketTree.SetDeferredDelete(true)
+	bucketTree.Remove(item)
+	_, inserted = bucketTree.GetOrInsert(newSimpleBucketItem([]byte("020"), []byte("050")))
+	re.True(inserted)
+	re.Equal(2, bucketTree.Len())
+}
+
+func TestInsertContiguousAfter(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	tree := NewRangeTree(2, bucketDebrisFactory)
+	first := newSimpleBucketItem([]byte("010"), []byte("020"))
+	tree.Update(first)
+	tree.Update(newSimpleBucketItem([]byte("040"), []byte("050")))
+
+	// the contiguous insertion.
+	second := newSimpleBucketItem([]byte("020"), []byte("030"))
+	re.NoError(tree.InsertContiguousAfter(first, second))
+	re.Equal(3, tree.Len())
+
+	version := tree.Version()
+	// a gap after prev.
+	re.Error(tree.InsertContiguousAfter(second, newSimpleBucketItem([]byte("035"), []byte("040"))))
+	// an overlap with prev.
+	re.Error(tree.InsertContiguousAfter(second, newSimpleBucketItem([]byte("025"), []byte("040"))))
+	// an overlap with the item after prev.
+	re.Error(tree.InsertContiguousAfter(second, newSimpleBucketItem([]byte("030"), []byte("045"))))
+	re.Error(tree.InsertContiguousAfter(second, newSimpleBucketItem([]byte("030"), nil)))
+	// prev is not in the tree or unbounded.
+	re.Error(tree.InsertContiguousAfter(newSimpleBucketItem([]byte("060"), []byte("070")), newSimpleBucketItem([]byte("070"), []byte("080"))))
+	re.Error(tree.InsertContiguousAfter(newSimpleBucketItem([]byte("040"), nil), newSimpleBucketItem([]byte("070"), []byte("080"))))
+	// prev has the start key of an item but not its end key, which would leave a gap.
+	re.Error(tree.InsertContiguousAfter(newSimpleBucketItem([]byte("020"), []byte("035")), newSimpleBucketItem([]byte("035"), []byte("040"))))
+	// an invalid item.
+	re.Error(tree.InsertContiguousAfter(second, newSimpleBucketItem([]byte("030"), []byte("020"))))
+	re.Equal(version, tree.Version())
+	re.Equal(3, tree.Len())
+
+	re.NoError(tree.InsertContiguousAfter(second, newSimpleBucketItem([]byte("030"), []byte("040"))))
+	re.Equal(4, tree.Len())
+	re.NoError(tree.Validate())
+}
+
+func TestDebrisObserver(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	// zeroFactory keeps the zero-length debris at the start key of the old item.
+	zeroFactory := func(startKey, endKey []byte, item RangeItem) []RangeItem {
+		return append(bucketDebrisFactory(startKey, endKey, item), newSimpleBucketItem(item.GetStartKey(), item.GetStartKey()))
+	}
+	bucketTree := NewRangeTree(2, zeroFactory)
+	var observed []string
+	bucketTree.SetDebrisObserver(func(src RangeItem, children []RangeItem) {
+		record := string(src.GetStartKey()) + "-" + string(src.GetEndKey()) + ":"
+		for _, child := range children {
+			record += " " + string(child.GetStartKey()) + "-" + string(child.GetEndKey())
+		}
+		observed = append(observed, record)
+	})
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("030")))
+	bucketTree.Update(newSimpleBucketItem([]byte("030"), []byte("050")))
+	re.Empty(observed)
+
+	_, debris := bucketTree.UpdateWithDebris(newSimpleBucketItem([]byte("020"), []byte("040")))
+	re.Equal([]string{"010-030: 010-020 010-010", "030-050: 040-050 030-030"}, observed)
+	// the zero-length children never enter the tree.
+	re.Len(debris, 2)
+	re.Equal(3, bucketTree.Len())
+	re.False(bucketTree.HasStartKey([]byte("030")))
+
+	// the enclosed overlap is observed without children.
+	observed = nil
+	bucketTree.Update(newSimpleBucketItem([]byte("015"), []byte("045")))
+	re.Equal([]string{"010-020: 010-015 010-010", "020-040:", "040-050: 045-050 040-040"}, observed)
+
+	bucketTree.SetDebrisObserver(nil)
+	bucketTree.Update(newSimpleBucketItem([]byte("000"), []byte("100")))
+	re.Len(observed, 3)
+}
+
+func TestUpdateEnclosedOverlaps(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	factoryCalls := 0
+	countingFactory := func(startKey, endKey []byte, item RangeItem) []RangeItem {
+		factoryCalls++
+		return bucketDebrisFactory(startKey, endKey, item)
+	}
+	keys := func(tree *RangeTree) []string {
+		var res []string
+		tree.ascend(func(item RangeItem) bool {
+			res = append(res, string(item.GetStartKey())+"-"+string(item.GetEndKey()))
+			return true
+		})
+		return res
+	}
+	testCases := []struct {
+		start, end   string
+		expect       []string
+		factoryCalls int
+	}{
+		// enclosed overlaps.
+		{"010", "040", []string{"000-010", "010-040", "040-050"}, 0},
+		{"000", "050", []string{"000-050"}, 0},
+		// straddling overlaps.
+		{"015", "035", []string{"000-010", "010-015", "015-035", "035-040", "040-050"}, 2},
+		{"015", "040", []string{"000-010", "010-015", "015-040", "040-050"}, 1},
+		// an enclosing overlap.
+		{"022", "028", []string{"000-010", "010-020", "020-022", "022-028", "028-030", "030-040", "040-050"}, 1},
+	}
+	for _, testCase := range testCases {
+		bucketTree := NewRangeTree(2, countingFactory)
+		for i := 0; i < 5; i++ {
+			bucketTree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%03d", i*10)), []byte(fmt.Sprintf("%03d", i*10+10))))
+		}
+		factoryCalls = 0
+		bucketTree.Update(newSimpleBucketItem([]byte(testCase.start), []byte(testCase.end)))
+		re.Equal(testCase.expect, keys(bucketTree))
+		re.Equal(testCase.factoryCalls, factoryCalls)
+	}
+}
+
+func BenchmarkUpdateEnclosedOverlaps(b *testing.B) {
+	tree := newReaderPoolTestTree(10000)
+	// every iteration splits a region into small ones, then replaces them by the big one.
+	start, end := []byte("050000"), []byte("050010")
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 10; j++ {
+			tree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%06d", 50000+j)), []byte(fmt.Sprintf("%06d", 50001+j))))
+		}
+		tree.Update(newSimpleBucketItem(start, end))
+	}
+}
+
+// newSparseTestTree returns a tree with 1/3 of its 30000 items left after the random deletions.
+func newSparseTestTree() *RangeTree {
+	tree := newReaderPoolTestTree(30000)
+	for _, i := range rand.Perm(30000) {
+		if i%3 != 0 {
+			tree.Remove(newSimpleBucketItem([]byte(fmt.Sprintf("%06d", i*10)), nil))
+		}
+	}
+	return tree
+}
+
+func TestCompactFillFactor(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	re.Equal(1.0, NewRangeTree(2, bucketDebrisFactory).FillFactor())
+	tree := newSparseTestTree()
+	fillFactor := tree.FillFactor()
+	re.Greater(fillFactor, 0.0)
+	items := tree.GetOverlaps(newSimpleBucketItem(nil, nil))
+	version := tree.Version()
+	tree.Compact()
+	re.Greater(tree.FillFactor(), 0.9)
+	re.Greater(tree.FillFactor(), fillFactor)
+	re.Equal(version, tree.Version())
+	re.Equal(10000, tree.Len())
+	re.Equal(items, tree.GetOverlaps(newSimpleBucketItem(nil, nil)))
+	re.Equal([]byte("000030"), tree.GetAt(1).GetStartKey())
+
+	// the tombstones are dropped by the compaction.
+	tree.SetDeferredDelete(true)
+	tree.Remove(newSimpleBucketItem([]byte("000000"), nil))
+	tree.Compact()
+	re.Equal(9999, tree.tree.Len())
+	re.Equal([]byte("000030"), tree.GetAt(0).GetStartKey())
+	tree.Update(newSimpleBucketItem([]byte("000000"), []byte("000040")))
+	re.Equal(9999, tree.Len())
+}
+
+func TestResize(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	tree := newReaderPoolTestTree(10000)
+	items := tree.GetOverlaps(newSimpleBucketItem(nil, nil))
+	_, _, err := tree.Resize(1)
+	re.Error(err)
+	re.Equal(32, tree.Degree())
+
+	oldStats, newStats, err := tree.Resize(2)
+	re.NoError(err)
+	re.Equal(32, oldStats.Degree)
+	re.Equal(2, newStats.Degree)
+	re.Equal(2, tree.Degree())
+	re.Greater(newStats.Height, oldStats.Height)
+	re.Greater(newStats.NodeCount, oldStats.NodeCount)
+	re.Greater(newStats.FillFactor, 0.75)
+	re.Equal(newStats.Height, tree.tree.Height())
+	re.Equal(items, tree.GetOverlaps(newSimpleBucketItem(nil, nil)))
+
+	// the tombstones are dropped.
+	tree.SetDeferredDelete(true)
+	tree.Remove(newSimpleBucketItem([]byte("000000"), nil))
+	oldStats, newStats, err = tree.Resize(64)
+	re.NoError(err)
+	re.Equal(2, oldStats.Degree)
+	re.Equal(64, newStats.Degree)
+	re.Less(newStats.Height, oldStats.Height)
+	re.Less(newStats.NodeCount, oldStats.NodeCount)
+	re.Equal(9999, tree.tree.Len())
+	re.Equal(items[1:], tree.GetOverlaps(newSimpleBucketItem(nil, nil)))
+	tree.Update(newSimpleBucketItem([]byte("000000"), []byte("000010")))
+	re.Equal(10000, tree.Len())
+	re.NoError(tree.Validate())
+}
+
+func BenchmarkScanSparseTree(b *testing.B) {
+	for _, compact := range []bool{false, true} {
+		tree := newSparseTestTree()
+		if compact {
+			tree.Compact()
+		}
+		b.Run(fmt.Sprintf("compact=%v", compact), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tree.ScanRange(newSimpleBucketItem([]byte(""), nil), func(RangeItem) bool {
+					return true
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkGetOverlaps(b *testing.B) {
+	tree := newReaderPoolTestTree(100000)
+	query := newSimpleBucketItem([]byte("050000"), []byte("050100"))
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tree.GetOverlaps(query)
+	}
+}
+
+func BenchmarkGetOverlapsWithPutOverlaps(b *testing.B) {
+	tree := newReaderPoolTestTree(100000)
+	query := newSimpleBucketItem([]byte("050000"), []byte("050100"))
+	b.ResetTimer()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		PutOverlaps(tree.GetOverlaps(query))
+	}
+}
+
+func TestAllowPoints(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	bucketTree.Update(newSimpleBucketItem([]byte("010"), []byte("030")))
+	bucketTree.Update(newSimpleBucketItem([]byte("030"), []byte("030")))
+	re.Equal(2, bucketTree.Len())
+	// the point marker can not be found without the point mode.
+	re.Nil(bucketTree.Find(newSimpleBucketItem([]byte("030"), nil)))
+
+	bucketTree.SetAllowPoints(true)
+	point := bucketTree.Find(newSimpleBucketItem([]byte("030"), nil))
+	re.NotNil(point)
+	re.Equal([]byte("030"), point.GetEndKey())
+	re.Nil(bucketTree.Find(newSimpleBucketItem([]byte("031"), nil)))
+	re.Equal([]byte("010"), bucketTree.Find(newSimpleBucketItem([]byte("029"), nil)).GetStartKey())
+
+	// the point marker overlaps with the ranges containing it.
+	re.Len(bucketTree.GetOverlaps(newSimpleBucketItem([]byte("020"), []byte("040"))), 2)
+	re.Len(bucketTree.GetOverlaps(newSimpleBucketItem([]byte("030"), []byte("040"))), 1)
+	re.Len(bucketTree.GetOverlaps(newSimpleBucketItem([]byte("020"), []byte("030"))), 1)
+	re.Len(bucketTree.GetOverlaps(newSimpleBucketItem([]byte("030"), []byte("030"))), 1)
+	results := bucketTree.GetOverlapsBatch([]RangeItem{
+		newSimpleBucketItem([]byte("030"), []byte("030")),
+		newSimpleBucketItem([]byte("020"), []byte("030")),
+		newSimpleBucketItem([]byte("020"), []byte("040")),
+	})
+	re.Equal([]int{1, 1, 2}, []int{len(results[0]), len(results[1]), len(results[2])})
+	re.True(bucketTree.Intersects([]byte("030"), []byte("030")))
+	re.True(bucketTree.Intersects([]byte("025"), []byte("035")))
+	re.True(bucketTree.Intersects([]byte("020"), []byte("020")))
+	re.False(bucketTree.Intersects([]byte("031"), []byte("031")))
+
+	// a surrounding range replaces the point marker.
+	bucketTree.Update(newSimpleBucketItem([]byte("025"), []byte("040")))
+	re.Equal(2, bucketTree.Len())
+	re.Equal([]byte("025"), bucketTree.Find(newSimpleBucketItem([]byte("030"), nil)).GetStartKey())
+
+	// the zero-length debris are kept as point markers.
+	pointFactory := func(_, _ []byte, item RangeItem) []RangeItem {
+		return []RangeItem{newSimpleBucketItem(item.GetStartKey(), item.GetStartKey())}
+	}
+	pointTree := NewRangeTree(2, pointFactory)
+	pointTree.Update(newSimpleBucketItem([]byte("010"), []byte("030")))
+	pointTree.Update(newSimpleBucketItem([]byte("020"), []byte("040")))
+	re.Equal(1, pointTree.Len())
+	pointTree.SetAllowPoints(true)
+	pointTree.Update(newSimpleBucketItem([]byte("030"), []byte("050")))
+	re.Equal(2, pointTree.Len())
+	re.Equal([]byte("020"), pointTree.Find(newSimpleBucketItem([]byte("020"), nil)).GetEndKey())
+	re.Nil(pointTree.Find(newSimpleBucketItem([]byte("025"), nil)))
+
+	// a point marker inside a range is rejected since it would replace the right debris of the range.
+	sidesFactory := func(startKey, endKey []byte, item RangeItem) []RangeItem {
+		return []RangeItem{
+			newSimpleBucketItem(item.GetStartKey(), startKey),
+			newSimpleBucketItem(endKey, item.GetEndKey()),
+		}
+	}
+	rangeTree := NewRangeTree(2, sidesFactory)
+	rangeTree.SetAllowPoints(true)
+	rangeTree.Update(newSimpleBucketItem([]byte("010"), []byte("050")))
+	version := rangeTree.Version()
+	overlaps, debris := rangeTree.UpdateWithDebris(newSimpleBucketItem([]byte("030"), []byte("030")))
+	re.Empty(overlaps)
+	re.Empty(debris)
+	re.Empty(rangeTree.Update(newSimpleBucketItem([]byte("010"), []byte("010"))))
+	re.Empty(rangeTree.UpdateWithPolicy(newSimpleBucketItem([]byte("030"), []byte("030")), func(RangeItem) OverlapAction {
+		return OverlapSplit
+	}))
+	actual, inserted := rangeTree.GetOrInsert(newSimpleBucketItem([]byte("030"), []byte("030")))
+	re.False(inserted)
+	re.Equal([]byte("010"), actual.GetStartKey())
+	re.Equal(version, rangeTree.Version())
+	re.Equal(1, rangeTree.Len())
+	re.Equal([]byte("010"), rangeTree.Find(newSimpleBucketItem([]byte("040"), nil)).GetStartKey())
+	// the point markers in a gap and at the end key of the range are kept.
+	rangeTree.Update(newSimpleBucketItem([]byte("050"), []byte("050")))
+	rangeTree.Update(newSimpleBucketItem([]byte("060"), []byte("060")))
+	re.Equal(3, rangeTree.Len())
+	re.NoError(rangeTree.Validate())
+	// a point marker replaces the point marker with the same key.
+	rangeTree.Update(newSimpleBucketItem([]byte("060"), []byte("060")))
+	re.Equal(3, rangeTree.Len())
+}
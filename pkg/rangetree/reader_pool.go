@@ -0,0 +1,69 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+import (
+	"sync"
+)
+
+// ReaderPool hands out the Readers of a RangeTree to serve the concurrent queries.
+// Every Reader reuses its own buffer for the query results, so the queries do not allocate
+// once the buffers grow large enough. The usage is:
+//
+//	reader := pool.Get()
+//	overlaps := reader.GetOverlaps(item)
+//	// use overlaps, which is only valid until the next query of the reader or Put.
+//	pool.Put(reader)
+//
+// A Reader must be used by one goroutine at a time, and the tree must not be mutated
+// while there are queries in progress.
+type ReaderPool struct {
+	tree *RangeTree
+	pool sync.Pool
+}
+
+// NewReaderPool creates a ReaderPool of the given tree.
+func NewReaderPool(tree *RangeTree) *ReaderPool {
+	p := &ReaderPool{tree: tree}
+	p.pool.New = func() interface{} {
+		return &Reader{tree: tree}
+	}
+	return p
+}
+
+// Get returns a Reader from the pool.
+func (p *ReaderPool) Get() *Reader {
+	return p.pool.Get().(*Reader)
+}
+
+// Put returns the Reader to the pool, the results of the Reader must not be used afterwards.
+func (p *ReaderPool) Put(reader *Reader) {
+	reader.buf = reader.buf[:0]
+	p.pool.Put(reader)
+}
+
+// Reader is a lightweight handle to query a RangeTree with a reused result buffer.
+type Reader struct {
+	tree *RangeTree
+	buf  []RangeItem
+}
+
+// GetOverlaps returns the range items that has some intersections with the given items.
+// The returned slice is only valid until the next GetOverlaps call or the Reader is put back, and it
+// must not be passed to PutOverlaps.
+func (r *Reader) GetOverlaps(item RangeItem) []RangeItem {
+	r.buf = r.tree.appendOverlaps(r.buf[:0], item, -1)
+	return r.buf
+}
@@ -0,0 +1,102 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newReaderPoolTestTree(count int) *RangeTree {
+	tree := NewRangeTree(32, bucketDebrisFactory)
+	for i := 0; i < count; i++ {
+		tree.Update(newSimpleBucketItem([]byte(fmt.Sprintf("%06d", i*10)), []byte(fmt.Sprintf("%06d", i*10+10))))
+	}
+	return tree
+}
+
+func TestReaderPool(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	tree := newReaderPoolTestTree(1000)
+	pool := NewReaderPool(tree)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			reader := pool.Get()
+			defer pool.Put(reader)
+			for i := 0; i < 1000; i++ {
+				count := (g+i)%5 + 1
+				start := (i * 7) % 990
+				query := newSimpleBucketItem([]byte(fmt.Sprintf("%06d", start*10)), []byte(fmt.Sprintf("%06d", (start+count)*10)))
+				overlaps := reader.GetOverlaps(query)
+				if len(overlaps) != count || !bytes.Equal(overlaps[0].GetStartKey(), query.startKey) {
+					errs <- fmt.Errorf("unexpected overlaps %v of query %v", overlaps, query)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		re.NoError(err)
+	}
+
+	// the buffer is reused by the reader.
+	reader := pool.Get()
+	overlaps := reader.GetOverlaps(newSimpleBucketItem([]byte("000000"), []byte("000050")))
+	re.Len(overlaps, 5)
+	re.Equal(tree.GetOverlaps(newSimpleBucketItem([]byte("000000"), []byte("000050"))), overlaps)
+	again := reader.GetOverlaps(newSimpleBucketItem([]byte("000100"), []byte("000120")))
+	re.Len(again, 2)
+	re.Equal(&overlaps[0], &again[0])
+	pool.Put(reader)
+}
+
+func BenchmarkReaderPoolGetOverlaps(b *testing.B) {
+	tree := newReaderPoolTestTree(100000)
+	pool := NewReaderPool(tree)
+	query := newSimpleBucketItem([]byte("050000"), []byte("050100"))
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		reader := pool.Get()
+		defer pool.Put(reader)
+		for pb.Next() {
+			reader.GetOverlaps(query)
+		}
+	})
+}
+
+func BenchmarkConcurrentGetOverlaps(b *testing.B) {
+	tree := newReaderPoolTestTree(100000)
+	query := newSimpleBucketItem([]byte("050000"), []byte("050100"))
+	b.ResetTimer()
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			tree.GetOverlaps(query)
+		}
+	})
+}
@@ -0,0 +1,263 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+import (
+	"bytes"
+	"math/big"
+	"math/bits"
+	"sort"
+	"sync/atomic"
+)
+
+// SizePercentile returns the p-th percentile of the lengths of the items, where p is in [0, 1].
+// The length is calculated by regarding the keys as big-endian integers padded to the max length
+// of the keys in the tree, and the unbounded items are excluded (see UnboundedCount). It returns
+// nil if there is no bounded item. It costs O(n*log(n)) and is only for diagnosis.
+func (r *RangeTree) SizePercentile(p float64) *big.Int {
+	var sizes []*big.Int
+	length := r.keyWidth()
+	r.ascend(func(item RangeItem) bool {
+		if len(item.GetEndKey()) > 0 {
+			sizes = append(sizes, keyDistance(item.GetStartKey(), item.GetEndKey(), length))
+		}
+		return true
+	})
+	if len(sizes) == 0 {
+		return nil
+	}
+	sort.Slice(sizes, func(i, j int) bool {
+		return sizes[i].Cmp(sizes[j]) < 0
+	})
+	index := int(p * float64(len(sizes)-1))
+	switch {
+	case index < 0:
+		index = 0
+	case index >= len(sizes):
+		index = len(sizes) - 1
+	}
+	return sizes[index]
+}
+
+// CoverageHistogram returns the count of the items bucketed by their lengths, where the length is
+// calculated by regarding the keys as big-endian integers padded to the max length of the keys in the
+// tree, so the boundaries are measured in the units of the longest key, and they must be in ascending
+// order. The i-th bucket counts the bounded items with lengths in [boundaries[i-1], boundaries[i]),
+// where the first bucket has no lower bound and the second to last one has no upper bound, and the last
+// bucket counts the unbounded items, i.e. there are len(boundaries)+2 buckets. It costs O(n*log(b)).
+func (r *RangeTree) CoverageHistogram(boundaries []*big.Int) []int {
+	counts := make([]int, len(boundaries)+2)
+	length := r.keyWidth()
+	r.ascend(func(item RangeItem) bool {
+		if len(item.GetEndKey()) == 0 {
+			counts[len(counts)-1]++
+			return true
+		}
+		l := keyDistance(item.GetStartKey(), item.GetEndKey(), length)
+		counts[sort.Search(len(boundaries), func(i int) bool {
+			return boundaries[i].Cmp(l) > 0
+		})]++
+		return true
+	})
+	return counts
+}
+
+// TreeStats is the structural stats of the btree of a tree, see Resize.
+type TreeStats struct {
+	Degree     int
+	Height     int
+	NodeCount  int
+	FillFactor float64
+}
+
+func (r *RangeTree) treeStats() TreeStats {
+	return TreeStats{
+		Degree:     r.degree,
+		Height:     r.tree.Height(),
+		NodeCount:  r.tree.NodeCount(),
+		FillFactor: r.FillFactor(),
+	}
+}
+
+// ChunkResult is a chunk of the key space with the items overlapping with it, see ChunkByKeyLength.
+type ChunkResult struct {
+	KeyRange
+	Items []RangeItem
+}
+
+// ChunkByKeyLength splits [start, end) into the consecutive chunks of chunkLen keys, and returns every
+// chunk with its overlaps in ascending order. The keys are regarded as big-endian integers of the
+// length of the longer one of start and end, and the last chunk may be shorter. An empty end means
+// unbounded, then the chunks are of the length of start and the last one is unbounded. The whole
+// window is one chunk if chunkLen is nil or not positive. It returns nil if the window is empty.
+func (r *RangeTree) ChunkByKeyLength(start, end []byte, chunkLen *big.Int) []ChunkResult {
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return nil
+	}
+	length := len(start)
+	if len(end) > length {
+		length = len(end)
+	}
+	var chunks []ChunkResult
+	for chunkStart := start; ; {
+		chunkEnd := end
+		if chunkLen != nil && chunkLen.Sign() > 0 {
+			if next := shiftKey(chunkStart, chunkLen, length); next != nil && (len(end) == 0 || bytes.Compare(next, end) < 0) {
+				chunkEnd = next
+			}
+		}
+		chunk := KeyRange{StartKey: chunkStart, EndKey: chunkEnd}
+		chunks = append(chunks, ChunkResult{KeyRange: chunk, Items: r.GetOverlaps(chunk)})
+		if bytes.Equal(chunkEnd, end) {
+			return chunks
+		}
+		chunkStart = chunkEnd
+	}
+}
+
+// WindowCount is a window of the key space with the count of the items overlapping with it, see
+// SlidingWindowCounts.
+type WindowCount struct {
+	KeyRange
+	Count int
+}
+
+// SlidingWindowCounts slides a window of windowLen keys by step keys over [start, end), and returns
+// every window with the count of its overlaps in ascending order. The keys are computed like
+// ChunkByKeyLength, the windows are clipped to [start, end), and the window starting beyond the max
+// key of the length ends the sliding if end is empty (unbounded). The overlaps are swept once by two
+// indexes moving forward, so it costs O(n+w) for n overlaps of [start, end) and w windows. It returns
+// nil if the window is empty, or windowLen or step is not positive.
+func (r *RangeTree) SlidingWindowCounts(start, end []byte, windowLen, step *big.Int) []WindowCount {
+	if (len(end) > 0 && bytes.Compare(start, end) >= 0) ||
+		windowLen == nil || windowLen.Sign() <= 0 || step == nil || step.Sign() <= 0 {
+		return nil
+	}
+	length := len(start)
+	if len(end) > length {
+		length = len(end)
+	}
+	items := r.GetOverlapsInRange(start, end)
+	var (
+		windows []WindowCount
+		// lo is the first item ending after the window start, and hi is the first item starting
+		// at or after the window end.
+		lo, hi int
+	)
+	for windowStart := start; windowStart != nil && (len(end) == 0 || bytes.Compare(windowStart, end) < 0); {
+		windowEnd := shiftKey(windowStart, windowLen, length)
+		if len(end) > 0 && (windowEnd == nil || bytes.Compare(windowEnd, end) > 0) {
+			windowEnd = end
+		}
+		for lo < len(items) && len(items[lo].GetEndKey()) > 0 && bytes.Compare(items[lo].GetEndKey(), windowStart) <= 0 {
+			lo++
+		}
+		if hi < lo {
+			hi = lo
+		}
+		for hi < len(items) && (len(windowEnd) == 0 || bytes.Compare(items[hi].GetStartKey(), windowEnd) < 0) {
+			hi++
+		}
+		windows = append(windows, WindowCount{KeyRange: KeyRange{StartKey: windowStart, EndKey: windowEnd}, Count: hi - lo})
+		windowStart = shiftKey(windowStart, step, length)
+	}
+	return windows
+}
+
+// UpdateOverlapHistogram returns the count of the updates bucketed by the count of their overlaps
+// since the last ResetStats. The i-th bucket counts the updates with [2^(i-1), 2^i) overlaps except
+// the first one counting the updates without overlap, i.e. the buckets are 0, 1, 2-3, 4-7 and so on.
+func (r *RangeTree) UpdateOverlapHistogram() []int {
+	return append([]int(nil), r.overlapHistogram...)
+}
+
+// FindMissRate returns the ratio of the overlap queries starting in a gap since the last ResetStats
+// or ResetOpStats, i.e. the queries whose start key is not contained by any item, so the scan starts from the query
+// itself. A high rate means the queries are not aligned with the stored ranges. The queries are only
+// counted when SetOpStats is enabled, and it returns 0 if there is no query. It is safe to call it
+// concurrently with the queries.
+func (r *RangeTree) FindMissRate() float64 {
+	hits, misses := atomic.LoadUint64(&r.findHits), atomic.LoadUint64(&r.findMisses)
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(misses) / float64(hits+misses)
+}
+
+// ResetStats resets the statistics of the tree.
+func (r *RangeTree) ResetStats() {
+	r.overlapHistogram = nil
+	atomic.StoreUint64(&r.findHits, 0)
+	atomic.StoreUint64(&r.findMisses, 0)
+}
+
+// OpStats is the cumulative count of the items visited by the queries, i.e. the invocations of the
+// btree callbacks, since the last ResetOpStats.
+type OpStats struct {
+	// OverlapVisits counts the items visited by GetOverlaps and the other overlap queries built on
+	// ScanOverlapping, including the item visited to find the start.
+	OverlapVisits uint64
+	// FindVisits counts the items visited by Find.
+	FindVisits uint64
+	// ScanRangeVisits counts the items visited by ScanRange and the scans built on it, including the
+	// item visited to find the start.
+	ScanRangeVisits uint64
+}
+
+// SetOpStats enables or disables the counting of OpStats and FindMissRate, it is disabled by default
+// so the queries cost nothing extra. It should not be called concurrently with the queries.
+func (r *RangeTree) SetOpStats(enabled bool) {
+	r.opStatsEnabled = enabled
+}
+
+// OpStats returns the count of the items visited by the queries since the last ResetOpStats, they are
+// only counted when SetOpStats is enabled. A visit count much larger than the result size means the
+// queries are scanning too many items. It is safe to call it concurrently with the queries.
+func (r *RangeTree) OpStats() OpStats {
+	return OpStats{
+		OverlapVisits:   atomic.LoadUint64(&r.opStats.OverlapVisits),
+		FindVisits:      atomic.LoadUint64(&r.opStats.FindVisits),
+		ScanRangeVisits: atomic.LoadUint64(&r.opStats.ScanRangeVisits),
+	}
+}
+
+// ResetOpStats resets the counters of OpStats and FindMissRate, which are both enabled by SetOpStats.
+func (r *RangeTree) ResetOpStats() {
+	atomic.StoreUint64(&r.opStats.OverlapVisits, 0)
+	atomic.StoreUint64(&r.opStats.FindVisits, 0)
+	atomic.StoreUint64(&r.opStats.ScanRangeVisits, 0)
+	atomic.StoreUint64(&r.findHits, 0)
+	atomic.StoreUint64(&r.findMisses, 0)
+}
+
+// countVisits wraps f to count its invocations to visits if OpStats is enabled, otherwise it
+// returns f as is.
+func (r *RangeTree) countVisits(visits *uint64, f func(item RangeItem) bool) func(item RangeItem) bool {
+	if !r.opStatsEnabled {
+		return f
+	}
+	return func(item RangeItem) bool {
+		atomic.AddUint64(visits, 1)
+		return f(item)
+	}
+}
+
+func (r *RangeTree) observeOverlaps(count int) {
+	bucket := bits.Len(uint(count))
+	for len(r.overlapHistogram) <= bucket {
+		r.overlapHistogram = append(r.overlapHistogram, 0)
+	}
+	r.overlapHistogram[bucket]++
+}
@@ -0,0 +1,269 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizePercentile(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	tree := NewRangeTree(2, bucketDebrisFactory)
+	re.Nil(tree.SizePercentile(0.5))
+	// the sizes are 1, 2, 3, 4, 5 which are not in the key order.
+	tree.Update(newSimpleBucketItem([]byte{0x00}, []byte{0x03}))
+	tree.Update(newSimpleBucketItem([]byte{0x03}, []byte{0x04}))
+	tree.Update(newSimpleBucketItem([]byte{0x04}, []byte{0x09}))
+	tree.Update(newSimpleBucketItem([]byte{0x09}, []byte{0x0b}))
+	tree.Update(newSimpleBucketItem([]byte{0x0b}, []byte{0x0f}))
+	re.Equal(int64(1), tree.SizePercentile(0).Int64())
+	re.Equal(int64(3), tree.SizePercentile(0.5).Int64())
+	re.Equal(int64(5), tree.SizePercentile(1.0).Int64())
+	re.Equal(int64(4), tree.SizePercentile(0.9).Int64())
+	re.Equal(int64(1), tree.SizePercentile(-1).Int64())
+	re.Equal(int64(5), tree.SizePercentile(2).Int64())
+
+	// the unbounded items are excluded.
+	tree.Update(newSimpleBucketItem([]byte{0x10}, []byte{}))
+	re.Equal(int64(5), tree.SizePercentile(1.0).Int64())
+	// the keys are padded to the longest key of the tree.
+	tree.Update(newSimpleBucketItem([]byte{0x0f}, []byte{0x0f, 0x07}))
+	re.Equal(int64(0x07), tree.SizePercentile(0).Int64())
+	re.Equal(int64(5<<8), tree.SizePercentile(1.0).Int64())
+	tree = NewRangeTree(2, bucketDebrisFactory)
+	tree.Update(newSimpleBucketItem([]byte{0x10}, []byte{}))
+	re.Nil(tree.SizePercentile(1.0))
+}
+
+func TestCoverageHistogram(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	boundaries := []*big.Int{big.NewInt(5), big.NewInt(10), big.NewInt(50)}
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	re.Equal([]int{0, 0, 0, 0, 0}, bucketTree.CoverageHistogram(boundaries))
+	// the lengths: 1, 5, 10, 10, 20, 50, 100 and unbounded.
+	bucketTree.Update(newSimpleBucketItem([]byte{0}, []byte{1}))
+	bucketTree.Update(newSimpleBucketItem([]byte{1}, []byte{6}))
+	bucketTree.Update(newSimpleBucketItem([]byte{6}, []byte{16}))
+	bucketTree.Update(newSimpleBucketItem([]byte{16}, []byte{26}))
+	bucketTree.Update(newSimpleBucketItem([]byte{26}, []byte{46}))
+	bucketTree.Update(newSimpleBucketItem([]byte{46}, []byte{96}))
+	bucketTree.Update(newSimpleBucketItem([]byte{96}, []byte{196}))
+	bucketTree.Update(newSimpleBucketItem([]byte{200}, []byte("")))
+
+	re.Equal([]int{1, 1, 3, 2, 1}, bucketTree.CoverageHistogram(boundaries))
+	re.Equal([]int{7, 1}, bucketTree.CoverageHistogram(nil))
+	re.Equal([]int{0, 7, 1}, bucketTree.CoverageHistogram([]*big.Int{big.NewInt(1)}))
+	re.Equal([]int{6, 1, 1}, bucketTree.CoverageHistogram([]*big.Int{big.NewInt(100)}))
+
+	// the keys are padded to the longest key of the tree, the lengths: 7 and 0x0100 to 0xc800.
+	bucketTree.Update(newSimpleBucketItem([]byte{196}, []byte{196, 7}))
+	re.Equal([]int{0, 1, 0, 7, 1}, bucketTree.CoverageHistogram(boundaries))
+	re.Equal([]int{1, 7, 1}, bucketTree.CoverageHistogram([]*big.Int{big.NewInt(1 << 8)}))
+}
+
+func TestChunkByKeyLength(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	bucketTree.Update(newSimpleBucketItem([]byte{0x10, 0x05}, []byte{0x10, 0x15}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x10, 0x15}, []byte{0x10, 0x18}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0x10, 0x30}, []byte{0x10, 0x50}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0xf4}, []byte("")))
+	type chunk struct {
+		start, end []byte
+		items      int
+	}
+	chunks := func(start, end []byte, chunkLen *big.Int) []chunk {
+		var res []chunk
+		for _, c := range bucketTree.ChunkByKeyLength(start, end, chunkLen) {
+			res = append(res, chunk{start: c.StartKey, end: c.EndKey, items: len(c.Items)})
+		}
+		return res
+	}
+	start, end := []byte{0x10, 0x00}, []byte{0x10, 0x40}
+	re.Equal([]chunk{
+		{[]byte{0x10, 0x00}, []byte{0x10, 0x10}, 1},
+		{[]byte{0x10, 0x10}, []byte{0x10, 0x20}, 2},
+		{[]byte{0x10, 0x20}, []byte{0x10, 0x30}, 0},
+		{[]byte{0x10, 0x30}, []byte{0x10, 0x40}, 1},
+	}, chunks(start, end, big.NewInt(0x10)))
+	// the last chunk is partial.
+	re.Equal([]chunk{
+		{[]byte{0x10, 0x00}, []byte{0x10, 0x18}, 2},
+		{[]byte{0x10, 0x18}, []byte{0x10, 0x30}, 0},
+		{[]byte{0x10, 0x30}, []byte{0x10, 0x40}, 1},
+	}, chunks(start, end, big.NewInt(0x18)))
+	// the keys of different lengths are regarded as the same length.
+	re.Equal([]chunk{
+		{[]byte{0x10}, []byte{0x10, 0x20}, 2},
+		{[]byte{0x10, 0x20}, []byte{0x10, 0x40}, 1},
+	}, chunks([]byte{0x10}, end, big.NewInt(0x20)))
+	// the whole window is one chunk.
+	re.Equal([]chunk{{start, end, 3}}, chunks(start, end, nil))
+	re.Equal([]chunk{{start, end, 3}}, chunks(start, end, big.NewInt(0)))
+	re.Equal([]chunk{{start, end, 3}}, chunks(start, end, big.NewInt(0x100)))
+	// the last chunk of the unbounded window is unbounded.
+	re.Equal([]chunk{
+		{[]byte{0xf0}, []byte{0xf8}, 1},
+		{[]byte{0xf8}, []byte(""), 1},
+	}, chunks([]byte{0xf0}, []byte(""), big.NewInt(0x08)))
+	re.Nil(chunks(end, start, big.NewInt(0x10)))
+}
+
+func TestSlidingWindowCounts(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	bucketTree := NewRangeTree(2, bucketDebrisFactory)
+	// a cluster of small items in [0x10, 0x18), a single item and an unbounded item.
+	for key := byte(0x10); key < 0x18; key += 2 {
+		bucketTree.Update(newSimpleBucketItem([]byte{key}, []byte{key + 2}))
+	}
+	bucketTree.Update(newSimpleBucketItem([]byte{0x30}, []byte{0x38}))
+	bucketTree.Update(newSimpleBucketItem([]byte{0xf0}, []byte("")))
+	counts := func(start, end []byte, windowLen, step int64) []int {
+		var res []int
+		for _, w := range bucketTree.SlidingWindowCounts(start, end, big.NewInt(windowLen), big.NewInt(step)) {
+			res = append(res, w.Count)
+			re.Len(bucketTree.GetOverlapsInRange(w.StartKey, w.EndKey), w.Count)
+		}
+		return res
+	}
+	// the count rises and falls as the window passes the cluster and the single item.
+	re.Equal([]int{0, 4, 4, 0, 0, 1, 1, 0}, counts([]byte{0x00}, []byte{0x40}, 0x10, 0x08))
+	windows := bucketTree.SlidingWindowCounts([]byte{0x00}, []byte{0x40}, big.NewInt(0x10), big.NewInt(0x08))
+	re.Equal(KeyRange{StartKey: []byte{0x08}, EndKey: []byte{0x18}}, windows[1].KeyRange)
+	// the last window is clipped.
+	re.Equal(KeyRange{StartKey: []byte{0x38}, EndKey: []byte{0x40}}, windows[7].KeyRange)
+	// the windows with gaps between them.
+	re.Equal([]int{1, 0, 1}, counts([]byte{0x10}, []byte{0x40}, 0x01, 0x10))
+	re.Equal([]int{2, 1, 2, 1}, counts([]byte{0x11}, []byte{0x15}, 0x02, 0x01))
+	// the last window of the unbounded window is unbounded.
+	re.Equal([]int{0, 1}, counts([]byte{0xe0}, []byte(""), 0x10, 0x10))
+	windows = bucketTree.SlidingWindowCounts([]byte{0xe0}, []byte(""), big.NewInt(0x10), big.NewInt(0x10))
+	re.Empty(windows[1].EndKey)
+
+	re.Nil(bucketTree.SlidingWindowCounts([]byte{0x00}, []byte{0x40}, big.NewInt(0), big.NewInt(1)))
+	re.Nil(bucketTree.SlidingWindowCounts([]byte{0x00}, []byte{0x40}, big.NewInt(1), big.NewInt(-1)))
+	re.Nil(bucketTree.SlidingWindowCounts([]byte{0x00}, []byte{0x40}, nil, big.NewInt(1)))
+	re.Nil(bucketTree.SlidingWindowCounts([]byte{0x40}, []byte{0x40}, big.NewInt(1), big.NewInt(1)))
+}
+
+func TestUpdateOverlapHistogram(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	tree := NewRangeTree(2, bucketDebrisFactory)
+	re.Empty(tree.UpdateOverlapHistogram())
+	// 10 updates without overlap.
+	for i := 0; i < 10; i++ {
+		tree.Update(newSimpleBucketItem([]byte{byte(i * 10)}, []byte{byte(i*10 + 10)}))
+	}
+	re.Equal([]int{10}, tree.UpdateOverlapHistogram())
+	// 1 overlap
+	tree.Update(newSimpleBucketItem([]byte{5}, []byte{8}))
+	// 3 overlaps: [0,5], [5,8], [8,10]
+	tree.Update(newSimpleBucketItem([]byte{0}, []byte{10}))
+	// 2 overlaps: [0,10], [10,20]
+	tree.Update(newSimpleBucketItem([]byte{5}, []byte{15}))
+	re.Equal([]int{10, 1, 2}, tree.UpdateOverlapHistogram())
+	// 5 overlaps: [0,5], [5,15], [15,20], [20,30], [30,40]
+	tree.Update(newSimpleBucketItem([]byte{0}, []byte{40}))
+	re.Equal([]int{10, 1, 2, 1}, tree.UpdateOverlapHistogram())
+
+	tree.ResetStats()
+	re.Empty(tree.UpdateOverlapHistogram())
+	tree.Update(newSimpleBucketItem([]byte{0}, []byte{40}))
+	re.Equal([]int{0, 1}, tree.UpdateOverlapHistogram())
+}
+
+func TestOpStats(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	tree := NewRangeTree(2, bucketDebrisFactory)
+	tree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	tree.Update(newSimpleBucketItem([]byte("030"), []byte("040")))
+	tree.Update(newSimpleBucketItem([]byte("050"), []byte("060")))
+
+	// nothing is counted by default.
+	re.NotNil(tree.Find(newSimpleBucketItem([]byte("035"), nil)))
+	re.Len(tree.GetOverlaps(newSimpleBucketItem([]byte("015"), []byte("055"))), 3)
+	re.Equal(OpStats{}, tree.OpStats())
+
+	tree.SetOpStats(true)
+	// Find visits the last item starting at or before the key.
+	re.NotNil(tree.Find(newSimpleBucketItem([]byte("035"), nil)))
+	re.Nil(tree.Find(newSimpleBucketItem([]byte("000"), nil)))
+	re.Equal(OpStats{FindVisits: 1}, tree.OpStats())
+	// GetOverlaps visits the start item once more and the first item after the query.
+	re.Len(tree.GetOverlaps(newSimpleBucketItem([]byte("015"), []byte("055"))), 3)
+	re.Equal(OpStats{OverlapVisits: 4, FindVisits: 1}, tree.OpStats())
+	re.Len(tree.GetOverlaps(newSimpleBucketItem([]byte("015"), []byte("035"))), 2)
+	re.Equal(OpStats{OverlapVisits: 8, FindVisits: 1}, tree.OpStats())
+	// ScanRange starting in a gap visits the item before the gap to find the start.
+	var scanned int
+	tree.ScanRange(newSimpleBucketItem([]byte("025"), nil), func(_ RangeItem) bool {
+		scanned++
+		return true
+	})
+	re.Equal(2, scanned)
+	re.Equal(OpStats{OverlapVisits: 8, FindVisits: 1, ScanRangeVisits: 3}, tree.OpStats())
+
+	tree.ResetOpStats()
+	re.Equal(OpStats{}, tree.OpStats())
+	tree.SetOpStats(false)
+	re.Len(tree.GetOverlaps(newSimpleBucketItem([]byte("015"), []byte("055"))), 3)
+	re.Equal(OpStats{}, tree.OpStats())
+}
+
+func TestFindMissRate(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	tree := NewRangeTree(2, bucketDebrisFactory)
+	re.Zero(tree.FindMissRate())
+	tree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	tree.Update(newSimpleBucketItem([]byte("030"), []byte("040")))
+	// nothing is counted by default.
+	re.Empty(tree.GetOverlaps(newSimpleBucketItem([]byte("050"), []byte("060"))))
+	re.Zero(tree.findMisses)
+	tree.SetOpStats(true)
+	tree.ResetStats()
+	re.Zero(tree.FindMissRate())
+
+	// the queries starting in an item.
+	re.Len(tree.GetOverlaps(newSimpleBucketItem([]byte("010"), []byte("030"))), 1)
+	re.Len(tree.GetOverlaps(newSimpleBucketItem([]byte("035"), []byte("050"))), 1)
+	re.Zero(tree.FindMissRate())
+	// the queries starting in a gap.
+	re.Len(tree.GetOverlaps(newSimpleBucketItem([]byte("020"), []byte("050"))), 1)
+	re.Empty(tree.GetOverlaps(newSimpleBucketItem([]byte("050"), []byte("060"))))
+	re.Equal(0.5, tree.FindMissRate())
+	re.Equal(uint64(2), tree.findHits)
+	re.Equal(uint64(2), tree.findMisses)
+
+	tree.ResetStats()
+	re.Zero(tree.FindMissRate())
+
+	// ResetOpStats resets the counters too.
+	re.Empty(tree.GetOverlaps(newSimpleBucketItem([]byte("050"), []byte("060"))))
+	re.Equal(1.0, tree.FindMissRate())
+	tree.ResetOpStats()
+	re.Zero(tree.FindMissRate())
+	re.Len(tree.GetOverlaps(newSimpleBucketItem([]byte("010"), []byte("030"))), 1)
+	re.Zero(tree.FindMissRate())
+}
@@ -0,0 +1,116 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+import (
+	"bytes"
+
+	"github.com/pingcap/errors"
+	"github.com/tikv/pd/pkg/btree"
+)
+
+// Validate checks the invariants of the tree, i.e. every item has a start key less than its end
+// key unless the end key is empty (unbounded) or it is a point marker (see SetAllowPoints), and the
+// consecutive items do not overlap. It returns the error describing the first violation, and it
+// costs O(n) so it is mainly for the tests and the diagnosis of the buggy factories.
+func (r *RangeTree) Validate() error {
+	var err error
+	var prev RangeItem
+	r.ascend(func(item RangeItem) bool {
+		if len(item.GetEndKey()) > 0 && bytes.Compare(item.GetStartKey(), item.GetEndKey()) >= 0 &&
+			!(r.allowPoints && isPoint(item)) {
+			err = errors.Errorf("invalid item [%q, %q)", item.GetStartKey(), item.GetEndKey())
+			return false
+		}
+		if prev != nil && Overlap(prev, item) {
+			err = errors.Errorf("item [%q, %q) overlaps with item [%q, %q)",
+				prev.GetStartKey(), prev.GetEndKey(), item.GetStartKey(), item.GetEndKey())
+			return false
+		}
+		prev = item
+		return true
+	})
+	return err
+}
+
+// OverlapGraph returns the items overlapping with each other in the tree, which can only be left by a
+// buggy factory or UpdateWithPolicy. Every item overlapping with some other items is mapped by its start
+// key, which is unique in the tree, to them in ascending order, so the graph is symmetric, and the items
+// without overlaps are absent. It sweeps the items in ascending order with the set of the items covering
+// the current start key, so it costs O(n*k) where k is the max count of the items covering a key.
+func (r *RangeTree) OverlapGraph() map[string][]RangeItem {
+	graph := make(map[string][]RangeItem)
+	var active []RangeItem
+	r.ascend(func(item RangeItem) bool {
+		// the active items ending before the item never overlap with the later items.
+		kept := active[:0]
+		for _, a := range active {
+			if len(a.GetEndKey()) == 0 || bytes.Compare(a.GetEndKey(), item.GetStartKey()) > 0 {
+				kept = append(kept, a)
+			}
+		}
+		active = kept
+		for _, a := range active {
+			if Overlap(a, item) {
+				graph[string(a.GetStartKey())] = append(graph[string(a.GetStartKey())], item)
+				graph[string(item.GetStartKey())] = append(graph[string(item.GetStartKey())], a)
+			}
+		}
+		active = append(active, item)
+		return true
+	})
+	return graph
+}
+
+// Repair fixes the overlapping consecutive items left by a buggy factory and returns the count of
+// the repairs. For every overlap [overlapStart, overlapEnd) of the consecutive items a and b, resolve
+// returns the winner which must be a or b, it is told by the start key so the items need not be
+// comparable. The loser is replaced by its debris outside the winner built by the factory, the invalid
+// debris dropped by Update and the debris still overlapping with the winner are dropped. Validate
+// returns nil after Repair unless the tree has other violations like an invalid item.
+func (r *RangeTree) Repair(resolve func(a, b RangeItem, overlapStart, overlapEnd []byte) RangeItem) int {
+	repairs := 0
+	var pivot btree.Item = KeyRange{}
+	for {
+		var a, b RangeItem
+		r.ascendGreaterOrEqual(pivot, func(item RangeItem) bool {
+			if a != nil && Overlap(a, item) {
+				b = item
+				return false
+			}
+			a = item
+			return true
+		})
+		if b == nil {
+			return repairs
+		}
+		overlapStart, overlapEnd := intersect(a, b)
+		winner, loser := a, b
+		// a and b are different items in the tree, so they have different start keys.
+		if bytes.Equal(resolve(a, b, overlapStart, overlapEnd).GetStartKey(), b.GetStartKey()) {
+			winner, loser = b, a
+		}
+		r.delete(loser)
+		for _, debris := range r.factory(winner.GetStartKey(), winner.GetEndKey(), loser) {
+			if r.validDebris(debris) && !Overlap(debris, winner) {
+				r.insert(debris)
+			}
+		}
+		r.version++
+		repairs++
+		// the items before a do not overlap with a, so they are not affected by the debris of a or b.
+		pivot = KeyRange{StartKey: a.GetStartKey()}
+	}
+}
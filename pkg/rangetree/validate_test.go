@@ -0,0 +1,204 @@
+// Copyright 2022 TiKV Project Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rangetree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newCorruptedTree returns a tree with the overlapping items inserted bypassing the factory.
+func newCorruptedTree(items ...RangeItem) *RangeTree {
+	tree := NewRangeTree(2, bucketDebrisFactory)
+	for _, item := range items {
+		tree.tree.ReplaceOrInsert(item)
+	}
+	return tree
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	tree := NewRangeTree(2, bucketDebrisFactory)
+	re.NoError(tree.Validate())
+	tree.Update(newSimpleBucketItem([]byte("010"), []byte("020")))
+	tree.Update(newSimpleBucketItem([]byte("020"), []byte("030")))
+	tree.Update(newSimpleBucketItem([]byte("040"), []byte("")))
+	re.NoError(tree.Validate())
+
+	tree = newCorruptedTree(
+		newSimpleBucketItem([]byte("010"), []byte("030")),
+		newSimpleBucketItem([]byte("020"), []byte("040")),
+	)
+	re.Error(tree.Validate())
+	tree = newCorruptedTree(newSimpleBucketItem([]byte("020"), []byte("010")))
+	re.Error(tree.Validate())
+	tree = newCorruptedTree(newSimpleBucketItem([]byte("020"), []byte("020")))
+	re.Error(tree.Validate())
+	tree.SetAllowPoints(true)
+	re.NoError(tree.Validate())
+}
+
+func TestFindAllContaining(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	tree := newGapTestTree("010", "020", "020", "030", "040", "")
+	re.Empty(tree.FindAllContaining([]byte("005")))
+	re.Empty(tree.FindAllContaining([]byte("030")))
+	for _, key := range []string{"010", "015", "020", "040", "100"} {
+		items := tree.FindAllContaining([]byte(key))
+		re.Len(items, 1)
+		re.Equal(tree.Find(newSimpleBucketItem([]byte(key), nil)), items[0])
+	}
+
+	// several items cover the same key, including the one separated from the key by others.
+	tree = newCorruptedTree(
+		newSimpleBucketItem([]byte("010"), []byte("100")),
+		newSimpleBucketItem([]byte("020"), []byte("030")),
+		newSimpleBucketItem([]byte("040"), []byte("060")),
+		newSimpleBucketItem([]byte("050"), []byte("")),
+		newSimpleBucketItem([]byte("055"), []byte("056")),
+	)
+	starts := func(key string) []string {
+		var res []string
+		for _, item := range tree.FindAllContaining([]byte(key)) {
+			res = append(res, string(item.GetStartKey()))
+		}
+		return res
+	}
+	re.Equal([]string{"010", "040", "050", "055"}, starts("055"))
+	re.Equal([]string{"010", "040", "050"}, starts("057"))
+	re.Equal([]string{"010", "050"}, starts("060"))
+	re.Equal([]string{"010", "020"}, starts("025"))
+	re.Equal([]string{"010"}, starts("035"))
+	re.Equal([]string{"050"}, starts("100"))
+	re.Empty(starts("005"))
+}
+
+func TestOverlapGraph(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	re.Empty(NewRangeTree(2, bucketDebrisFactory).OverlapGraph())
+	re.Empty(newGapTestTree("010", "020", "020", "030", "040", "").OverlapGraph())
+
+	items := map[string]RangeItem{
+		"a": newSimpleBucketItem([]byte("010"), []byte("050")),
+		"b": newSimpleBucketItem([]byte("020"), []byte("030")),
+		"c": newSimpleBucketItem([]byte("025"), []byte("060")),
+		"d": newSimpleBucketItem([]byte("055"), []byte("070")),
+		"e": newSimpleBucketItem([]byte("080"), []byte("")),
+		"f": newSimpleBucketItem([]byte("090"), []byte("100")),
+		"g": newSimpleBucketItem([]byte("100"), []byte("110")),
+		"h": newSimpleBucketItem([]byte("000"), []byte("005")),
+	}
+	tree := newCorruptedTree()
+	for _, item := range items {
+		tree.tree.ReplaceOrInsert(item)
+	}
+	names := make(map[string]string)
+	for name, item := range items {
+		names[string(item.GetStartKey())] = name
+	}
+	graph := make(map[string]string)
+	for startKey, overlaps := range tree.OverlapGraph() {
+		for _, over := range overlaps {
+			graph[names[startKey]] += names[string(over.GetStartKey())]
+		}
+	}
+	re.Equal(map[string]string{
+		"a": "bc", "b": "ac", "c": "abd", "d": "c", "e": "fg", "f": "e", "g": "e",
+	}, graph)
+	// the graph is symmetric.
+	for item, overlaps := range graph {
+		for _, over := range overlaps {
+			re.Contains(graph[string(over)], item)
+		}
+	}
+
+	// the items need not be comparable.
+	keyRanges := newCorruptedTree(
+		KeyRange{StartKey: []byte("010"), EndKey: []byte("030")},
+		KeyRange{StartKey: []byte("020"), EndKey: []byte("040")},
+	)
+	re.Equal(map[string][]RangeItem{
+		"010": {KeyRange{StartKey: []byte("020"), EndKey: []byte("040")}},
+		"020": {KeyRange{StartKey: []byte("010"), EndKey: []byte("030")}},
+	}, keyRanges.OverlapGraph())
+}
+
+func TestRepair(t *testing.T) {
+	t.Parallel()
+	re := require.New(t)
+	keys := func(tree *RangeTree) []string {
+		var res []string
+		tree.ascend(func(item RangeItem) bool {
+			res = append(res, string(item.GetStartKey())+"-"+string(item.GetEndKey()))
+			return true
+		})
+		return res
+	}
+	firstWins := func(a, _ RangeItem, _, _ []byte) RangeItem {
+		return a
+	}
+	secondWins := func(_, b RangeItem, _, _ []byte) RangeItem {
+		return b
+	}
+	newTree := func() *RangeTree {
+		return newCorruptedTree(
+			newSimpleBucketItem([]byte("000"), []byte("100")),
+			newSimpleBucketItem([]byte("020"), []byte("030")),
+			newSimpleBucketItem([]byte("025"), []byte("050")),
+			newSimpleBucketItem([]byte("090"), []byte("200")),
+		)
+	}
+
+	tree := newTree()
+	re.Zero(newCorruptedTree().Repair(firstWins))
+	re.Equal(3, tree.Repair(firstWins))
+	re.NoError(tree.Validate())
+	re.Equal([]string{"000-100", "100-200"}, keys(tree))
+
+	tree = newTree()
+	version := tree.Version()
+	re.Equal(4, tree.Repair(secondWins))
+	re.NoError(tree.Validate())
+	re.Equal([]string{"000-020", "020-025", "025-030", "030-090", "090-200"}, keys(tree))
+	re.Greater(tree.Version(), version)
+	re.Zero(tree.Repair(secondWins))
+
+	// the items need not be comparable.
+	tree = newCorruptedTree(
+		KeyRange{StartKey: []byte("000"), EndKey: []byte("030")},
+		KeyRange{StartKey: []byte("020"), EndKey: []byte("050")},
+	)
+	re.Equal(1, tree.Repair(secondWins))
+	re.NoError(tree.Validate())
+	re.Equal([]string{"000-020", "020-050"}, keys(tree))
+
+	// the invalid debris are dropped like Update.
+	invertedFactory := func(startKey, endKey []byte, item RangeItem) []RangeItem {
+		return append(bucketDebrisFactory(startKey, endKey, item),
+			newSimpleBucketItem([]byte("d"), []byte("c")), newSimpleBucketItem([]byte("e"), []byte("e")))
+	}
+	tree = newCorruptedTree(
+		newSimpleBucketItem([]byte("a"), []byte("c")),
+		newSimpleBucketItem([]byte("b"), []byte("c")),
+	)
+	tree.factory = invertedFactory
+	re.Equal(1, tree.Repair(secondWins))
+	re.NoError(tree.Validate())
+	re.Equal([]string{"a-b", "b-c"}, keys(tree))
+}